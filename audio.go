@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/movsb/taones/nes"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// 动态码率控制：根据队列中的数据量微调重采样比例，
+// 让声卡消耗的速度和模拟器产生的速度保持一致
+const (
+	audioMaxDelta = 0.005 // 最大调整 0.5%，人耳听不出音高变化
+	audioLatency  = 0.05  // 目标队列长度（秒）
+)
+
+type AudioOutput struct {
+	dev       sdl.AudioDeviceID
+	resampler *nes.Resampler
+	target    uint32 // 目标队列字节数
+	samples   []float32
+	data      []byte
+}
+
+// cpuFreq 是模拟器的 CPU 频率，即 APU 的采样率
+func NewAudioOutput(cpuFreq float64, rate int) (*AudioOutput, error) {
+	desired := &sdl.AudioSpec{
+		Freq:     int32(rate),
+		Format:   sdl.AUDIO_F32LSB,
+		Channels: 1,
+		Samples:  1024,
+	}
+	obtained := &sdl.AudioSpec{}
+
+	dev, err := sdl.OpenAudioDevice("", false, desired, obtained, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &AudioOutput{
+		dev:       dev,
+		resampler: nes.NewResampler(cpuFreq, int(obtained.Freq)),
+		target:    uint32(float64(obtained.Freq)*audioLatency) * 4,
+	}
+
+	sdl.PauseAudioDevice(dev, false)
+
+	return o, nil
+}
+
+func (o *AudioOutput) Sampler() nes.Sampler {
+	return o.resampler.Sample
+}
+
+// Flush 把重采样好的数据送到声卡，并调整重采样比例
+func (o *AudioOutput) Flush() {
+	o.samples = o.resampler.Read(o.samples[:0])
+	if len(o.samples) == 0 {
+		return
+	}
+
+	o.data = o.data[:0]
+	for _, s := range o.samples {
+		o.data = binary.LittleEndian.AppendUint32(o.data, math.Float32bits(s))
+	}
+
+	queued := sdl.GetQueuedAudioSize(o.dev)
+
+	// 卡顿太久积压了太多数据，直接丢掉，免得声音一直延迟
+	if queued > o.target*4 {
+		sdl.ClearQueuedAudio(o.dev)
+		queued = 0
+	}
+
+	sdl.QueueAudio(o.dev, o.data)
+
+	diff := (float64(o.target) - float64(queued)) / float64(o.target)
+	diff = math.Max(-1, math.Min(1, diff))
+	o.resampler.SetAdjust(diff * audioMaxDelta)
+}
+
+func (o *AudioOutput) Close() {
+	sdl.CloseAudioDevice(o.dev)
+}
@@ -0,0 +1,5 @@
+module github.com/movsb/taones
+
+go 1.21
+
+require github.com/veandco/go-sdl2 v0.4.40
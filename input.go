@@ -0,0 +1,546 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/movsb/taones/nes"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+/*
+ 按键绑定的配置文件（JSON），每个玩家一张表，每个按钮可以绑定多个输入：
+
+ {
+   "deadzone": 8000,
+   "players": [
+     {"up": ["key:W", "button:dpup", "axis:lefty-"], "a": ["key:K", "button:b"], ...},
+     {"up": ["key:Up"], ...}
+   ],
+   "turbo": [{"on": 1, "off": 3}, {"on": 1, "off": 3}]
+ }
+
+ 按钮的名字是 a b select start up down left right，
+ 前面加 turbo 是这个按钮的连发键，比如 turboa。
+ "turbo" 是每个玩家连发的节奏：按下 on 帧、松开 off 帧。
+
+ key:    键盘按键，SDL 的按键名，比如 W、Up、Right Shift
+ button: 手柄按钮，SDL 的名字：a b x y back start leftshoulder dpup ...
+ axis:   手柄摇杆，SDL 的名字加方向：leftx- leftx+ lefty- lefty+ ...
+
+ 手柄按插入的顺序分给 1P、2P，拔掉之后空出来的位置给下一个插入的手柄。
+*/
+
+// 可以绑定的按钮：前 8 个和 nes.ButtonA 等一致，后 8 个是它们的连发键
+const (
+	inputTurbo   = 8
+	inputTurboA  = inputTurbo + nes.ButtonA
+	inputTurboB  = inputTurbo + nes.ButtonB
+	inputButtons = 16
+)
+
+var inputButtonNames = func() (names [inputButtons]string) {
+	buttons := [8]string{
+		nes.ButtonA:      "a",
+		nes.ButtonB:      "b",
+		nes.ButtonSelect: "select",
+		nes.ButtonStart:  "start",
+		nes.ButtonUp:     "up",
+		nes.ButtonDown:   "down",
+		nes.ButtonLeft:   "left",
+		nes.ButtonRight:  "right",
+	}
+	for i, name := range buttons {
+		names[i] = name
+		names[inputTurbo+i] = "turbo" + name
+	}
+	return
+}()
+
+// 绑定流程中依次询问的顺序，其它按钮的连发键只能在配置文件里设置
+var inputBindOrder = [...]int{
+	nes.ButtonUp, nes.ButtonDown, nes.ButtonLeft, nes.ButtonRight,
+	nes.ButtonSelect, nes.ButtonStart, nes.ButtonB, nes.ButtonA,
+	inputTurboB, inputTurboA,
+}
+
+const defaultDeadZone = 8000
+
+// 默认的连发节奏：每 4 帧按一次
+const (
+	defaultTurboOn  = 1
+	defaultTurboOff = 3
+)
+
+type bindingKind byte
+
+const (
+	bindKey bindingKind = iota
+	bindButton
+	bindAxis
+)
+
+// 一个输入：键盘按键、手柄按钮或者摇杆的一个方向
+type binding struct {
+	kind   bindingKind
+	key    sdl.Keycode
+	button sdl.GameControllerButton
+	axis   sdl.GameControllerAxis
+	sign   int // 摇杆方向，1 或者 -1
+}
+
+func parseBinding(s string) (binding, error) {
+	kind, name, _ := strings.Cut(s, ":")
+	switch kind {
+	case "key":
+		if key := sdl.GetKeyFromName(name); key != sdl.K_UNKNOWN {
+			return binding{kind: bindKey, key: key}, nil
+		}
+	case "button":
+		if button := sdl.GameControllerGetButtonFromString(name); button != sdl.CONTROLLER_BUTTON_INVALID {
+			return binding{kind: bindButton, button: button}, nil
+		}
+	case "axis":
+		sign := 1
+		if strings.HasSuffix(name, "-") {
+			sign = -1
+		}
+		name = strings.TrimRight(name, "+-")
+		if axis := sdl.GameControllerGetAxisFromString(name); axis != sdl.CONTROLLER_AXIS_INVALID {
+			return binding{kind: bindAxis, axis: axis, sign: sign}, nil
+		}
+	}
+	return binding{}, fmt.Errorf("invalid binding: %s", s)
+}
+
+func (b binding) String() string {
+	switch b.kind {
+	case bindKey:
+		return "key:" + sdl.GetKeyName(b.key)
+	case bindButton:
+		return "button:" + sdl.GameControllerGetStringForButton(b.button)
+	default:
+		sign := "+"
+		if b.sign < 0 {
+			sign = "-"
+		}
+		return "axis:" + sdl.GameControllerGetStringForAxis(b.axis) + sign
+	}
+}
+
+// 键盘和手柄是两类，绑定流程中新的输入只替换同一类的旧绑定
+func (b binding) pad() bool {
+	return b.kind != bindKey
+}
+
+type playerBindings [inputButtons][]binding
+
+type inputConfig struct {
+	deadZone int
+	players  [2]playerBindings
+	turbo    [2]turboRate
+}
+
+type turboRate struct {
+	On  int `json:"on"`
+	Off int `json:"off"`
+}
+
+// 配置文件的格式
+type inputConfigFile struct {
+	DeadZone int                   `json:"deadzone"`
+	Players  []map[string][]string `json:"players"`
+	Turbo    []turboRate           `json:"turbo"`
+}
+
+func mustBindings(names ...string) []binding {
+	var bindings []binding
+	for _, name := range names {
+		b, err := parseBinding(name)
+		if err != nil {
+			panic(err)
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings
+}
+
+// 默认的绑定，键盘和原来一样
+func defaultInputConfig() *inputConfig {
+	config := &inputConfig{deadZone: defaultDeadZone}
+
+	keys := [2][inputButtons]string{
+		{"K", "J", "T", "Y", "W", "S", "A", "D", "I", "U"},
+		{".", ",", "Right Shift", "Return", "Up", "Down", "Left", "Right", ";", "L"},
+	}
+	pad := [inputButtons][]string{
+		nes.ButtonA:      {"button:b"},
+		nes.ButtonB:      {"button:a"},
+		nes.ButtonSelect: {"button:back"},
+		nes.ButtonStart:  {"button:start"},
+		nes.ButtonUp:     {"button:dpup", "axis:lefty-"},
+		nes.ButtonDown:   {"button:dpdown", "axis:lefty+"},
+		nes.ButtonLeft:   {"button:dpleft", "axis:leftx-"},
+		nes.ButtonRight:  {"button:dpright", "axis:leftx+"},
+		inputTurboA:      {"button:x"},
+		inputTurboB:      {"button:y"},
+	}
+
+	for player := range config.players {
+		for button := range config.players[player] {
+			var names []string
+			if key := keys[player][button]; key != "" {
+				names = append(names, "key:"+key)
+			}
+			config.players[player][button] = mustBindings(append(names, pad[button]...)...)
+		}
+		config.turbo[player] = turboRate{On: defaultTurboOn, Off: defaultTurboOff}
+	}
+
+	return config
+}
+
+// 默认放在用户配置目录下：taones/input.json
+func defaultInputConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "input.json"
+	}
+	return filepath.Join(dir, "taones", "input.json")
+}
+
+// 文件不存在时使用默认绑定；文件里没有写的按钮也保持默认
+func loadInputConfig(path string) (*inputConfig, error) {
+	config := defaultInputConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file inputConfigFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if file.DeadZone > 0 {
+		config.deadZone = file.DeadZone
+	}
+
+	for player, rate := range file.Turbo {
+		if player >= len(config.turbo) {
+			break
+		}
+		if rate.On < 1 || rate.Off < 1 {
+			return nil, fmt.Errorf("%s: invalid turbo rate: %d/%d", path, rate.On, rate.Off)
+		}
+		config.turbo[player] = rate
+	}
+
+	for player, table := range file.Players {
+		if player >= len(config.players) {
+			break
+		}
+		for name, names := range table {
+			button := inputButtonByName(name)
+			if button < 0 {
+				return nil, fmt.Errorf("%s: unknown button: %s", path, name)
+			}
+			bindings := []binding{}
+			for _, name := range names {
+				b, err := parseBinding(name)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", path, err)
+				}
+				bindings = append(bindings, b)
+			}
+			config.players[player][button] = bindings
+		}
+	}
+
+	return config, nil
+}
+
+func inputButtonByName(name string) int {
+	for i, n := range inputButtonNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *inputConfig) save(path string) error {
+	file := inputConfigFile{DeadZone: o.deadZone, Turbo: o.turbo[:]}
+	for _, bindings := range o.players {
+		table := map[string][]string{}
+		for button, list := range bindings {
+			names := []string{}
+			for _, b := range list {
+				names = append(names, b.String())
+			}
+			table[inputButtonNames[button]] = names
+		}
+		file.Players = append(file.Players, table)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Input 把键盘和手柄的状态转换成两个玩家的按键
+type Input struct {
+	config *inputConfig
+	path   string
+	pads   [2]*sdl.GameController // 每个玩家的手柄，没有插时为空
+
+	// 绑定流程
+	binding   bool
+	player    int
+	step      int                    // inputBindOrder 中的位置
+	waitAxis  sdl.GameControllerAxis // 刚绑定的摇杆，回中之前不接受摇杆输入
+	waitValid bool
+}
+
+func NewInput(config *inputConfig, path string) *Input {
+	return &Input{config: config, path: path}
+}
+
+// 关闭所有的手柄
+func (o *Input) Close() {
+	for i, pad := range o.pads {
+		if pad != nil {
+			pad.Close()
+			o.pads[i] = nil
+		}
+	}
+}
+
+// Player 返回 player 的输入来源
+func (o *Input) Player(player int) playerInput {
+	rate := o.config.turbo[player]
+	return playerInput{
+		buttons: func(frameCounter uint64) [8]bool {
+			pressed := o.pressed(player)
+			return [8]bool(pressed[:8])
+		},
+		turbo: func(frameCounter uint64) [8]bool {
+			pressed := o.pressed(player)
+			return [8]bool(pressed[inputTurbo:])
+		},
+		on:  rate.On,
+		off: rate.Off,
+	}
+}
+
+// 按下了哪些按钮（包括连发键）
+func (o *Input) pressed(player int) [inputButtons]bool {
+	var pressed [inputButtons]bool
+
+	// 绑定的时候不往游戏里输入
+	if o.binding {
+		return pressed
+	}
+
+	keys := sdl.GetKeyboardState()
+	pad := o.pads[player]
+
+	for button, bindings := range o.config.players[player] {
+		for _, b := range bindings {
+			if o.active(b, keys, pad) {
+				pressed[button] = true
+				break
+			}
+		}
+	}
+
+	return pressed
+}
+
+func (o *Input) active(b binding, keys []uint8, pad *sdl.GameController) bool {
+	switch b.kind {
+	case bindKey:
+		sc := sdl.GetScancodeFromKey(b.key)
+		return int(sc) < len(keys) && keys[sc] != 0
+	case bindButton:
+		return pad != nil && pad.Button(b.button) != 0
+	default:
+		return pad != nil && int(pad.Axis(b.axis))*b.sign > o.config.deadZone
+	}
+}
+
+// 手柄的热插拔
+func (o *Input) addPad(index int) {
+	if !sdl.IsGameController(index) {
+		return
+	}
+
+	pad := sdl.GameControllerOpen(index)
+	if pad == nil {
+		log.Println("input:", sdl.GetError())
+		return
+	}
+
+	// 同一个手柄可能收到两次 ADDED 事件
+	id := pad.Joystick().InstanceID()
+	for _, p := range o.pads {
+		if p != nil && p.Joystick().InstanceID() == id {
+			pad.Close()
+			return
+		}
+	}
+
+	for player, p := range o.pads {
+		if p == nil {
+			o.pads[player] = pad
+			log.Printf("input: %s connected as player %d", pad.Name(), player+1)
+			return
+		}
+	}
+
+	// 两个玩家都有手柄了
+	pad.Close()
+}
+
+func (o *Input) removePad(id sdl.JoystickID) {
+	for player, pad := range o.pads {
+		if pad != nil && pad.Joystick().InstanceID() == id {
+			log.Printf("input: %s disconnected from player %d", pad.Name(), player+1)
+			pad.Close()
+			o.pads[player] = nil
+		}
+	}
+}
+
+// 事件来自 player 的手柄
+func (o *Input) fromPad(player int, id sdl.JoystickID) bool {
+	pad := o.pads[player]
+	return pad != nil && pad.Joystick().InstanceID() == id
+}
+
+// StartBinding 开始给 player 绑定按键，依次按下每个按钮对应的键
+func (o *Input) StartBinding(player int) {
+	o.binding = true
+	o.player = player
+	o.step = 0
+	o.waitValid = false
+}
+
+// Binding 是否在绑定流程中
+func (o *Input) Binding() bool {
+	return o.binding
+}
+
+// BindPrompt 返回绑定流程的提示
+func (o *Input) BindPrompt() string {
+	if !o.binding {
+		return ""
+	}
+	button := inputButtonNames[inputBindOrder[o.step]]
+	return fmt.Sprintf("player %d %s: press a key or button (Esc to skip)", o.player+1, button)
+}
+
+// HandleEvent 处理手柄的插拔和绑定流程中的输入，返回事件是否已经被处理
+func (o *Input) HandleEvent(event sdl.Event) bool {
+	switch evt := event.(type) {
+	case *sdl.ControllerDeviceEvent:
+		switch evt.Type {
+		case sdl.CONTROLLERDEVICEADDED:
+			o.addPad(int(evt.Which))
+		case sdl.CONTROLLERDEVICEREMOVED:
+			o.removePad(evt.Which)
+		}
+		return true
+	}
+
+	if !o.binding {
+		return false
+	}
+
+	switch evt := event.(type) {
+	case *sdl.KeyboardEvent:
+		if evt.Type != sdl.KEYDOWN || evt.Repeat != 0 {
+			return true
+		}
+		if evt.Keysym.Sym == sdl.K_ESCAPE {
+			o.nextStep()
+			return true
+		}
+		o.bind(binding{kind: bindKey, key: evt.Keysym.Sym})
+		return true
+	case *sdl.ControllerButtonEvent:
+		if evt.Type == sdl.CONTROLLERBUTTONDOWN && o.fromPad(o.player, evt.Which) {
+			o.bind(binding{kind: bindButton, button: sdl.GameControllerButton(evt.Button)})
+		}
+		return true
+	case *sdl.ControllerAxisEvent:
+		if !o.fromPad(o.player, evt.Which) {
+			return true
+		}
+		axis := sdl.GameControllerAxis(evt.Axis)
+		value := int(evt.Value)
+		if value < 0 {
+			value = -value
+		}
+		if o.waitValid && axis == o.waitAxis {
+			if value <= o.config.deadZone {
+				o.waitValid = false
+			}
+			return true
+		}
+		if value > o.config.deadZone {
+			sign := 1
+			if evt.Value < 0 {
+				sign = -1
+			}
+			o.waitAxis, o.waitValid = axis, true
+			o.bind(binding{kind: bindAxis, axis: axis, sign: sign})
+		}
+		return true
+	}
+
+	return false
+}
+
+// 新的绑定替换这个按钮同一类（键盘或者手柄）的旧绑定
+func (o *Input) bind(b binding) {
+	button := inputBindOrder[o.step]
+	bindings := &o.config.players[o.player][button]
+
+	list := []binding{b}
+	for _, old := range *bindings {
+		if old.pad() != b.pad() {
+			list = append(list, old)
+		}
+	}
+	*bindings = list
+
+	o.nextStep()
+}
+
+func (o *Input) nextStep() {
+	if o.step++; o.step < len(inputBindOrder) {
+		return
+	}
+
+	o.binding = false
+	if err := o.config.save(o.path); err != nil {
+		log.Println("input:", err)
+		return
+	}
+	log.Println("input: bindings saved to", o.path)
+}
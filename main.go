@@ -2,36 +2,360 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/movsb/taones/nes"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// 定时保存存档的间隔（毫秒）
+const sramSaveInterval = 5000
+
 var config struct {
-	opcodes bool
-	scale   uint
+	opcodes    bool
+	scale      uint
+	audioRate  int
+	region     string
+	palette    string
+	saveDir    string
+	fullscreen bool
+	slot       int
+	headless   bool
+	record     string
+	input      string
+	zapper     bool
+	movie      string
+	saveMovie  string
+
+	rewind         float64
+	rewindInterval int
+}
+
+// 一次运行的状态
+type emulator struct {
+	console  *nes.Console
+	cart     *nes.Cartridge
+	romPath  string
+	saveDir  string // 存档目录
+	savePath string // SRAM 存档路径
+	slot     int    // 当前即时存档的槽位
+
+	rewinder *nes.Rewinder // 未开启倒带时为空
+	halted   bool          // 已经报告过 CPU 停机
+
+	inputs    [2]playerInput     // 1P 和 2P 的输入
+	zapper    *nes.Zapper        // 插在 2P 口的光枪，没有时为空
+	movieRec  *nes.MovieRecorder // 正在录制输入录像
+	moviePath string             // 输入录像保存的位置
+	player    *nes.MoviePlayer   // 正在回放输入录像
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <rom.nes>\n", name)
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s run -frames N -rom <rom.nes> [-dump out.png] [-record out.avi]\n", name)
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s run -movie <movie.fm2> -rom <rom.nes>\n", name)
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s test [flags] <dir>\n\nflags:\n", name)
+	flag.PrintDefaults()
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			os.Exit(runFrames(os.Args[2:]))
+		case "test":
+			os.Exit(runTests(os.Args[2:]))
+		}
+	}
+
 	flag.BoolVar(&config.opcodes, "opcodes", false, "show opcodes")
 	flag.UintVar(&config.scale, "scale", 2, "video scaler")
+	flag.IntVar(&config.audioRate, "audio", 44100, "audio sample rate, 0 to disable audio")
+	flag.StringVar(&config.region, "region", "auto", "console region: auto, ntsc, pal or dendy")
+	flag.StringVar(&config.palette, "palette", "", "load colors from a .pal file")
+	flag.StringVar(&config.saveDir, "savedir", "", "directory for saves (default: next to the rom)")
+	flag.BoolVar(&config.fullscreen, "fullscreen", false, "start in fullscreen")
+	flag.IntVar(&config.slot, "slot", 0, "initial save state slot (0-9)")
+	flag.BoolVar(&config.headless, "headless", false, "run without window and audio")
+	flag.StringVar(&config.input, "input", defaultInputConfigPath(), "key and gamepad bindings file")
+	flag.BoolVar(&config.zapper, "zapper", false, "plug a zapper aimed with the mouse into port 2")
+	flag.StringVar(&config.record, "record", "", "record video and audio to an avi file from the start")
+	flag.StringVar(&config.movie, "movie", "", "play back an fm2 input movie from power on")
+	flag.StringVar(&config.saveMovie, "record-movie", "", "record an fm2 input movie from power on")
+	flag.Float64Var(&config.rewind, "rewind", 10, "seconds of rewind history, 0 to disable rewind")
+	flag.IntVar(&config.rewindInterval, "rewind-interval", 1, "capture a rewind state every N frames")
+	flag.Usage = usage
 	flag.Parse()
 
-	var err error
-	_ = err
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if config.slot < 0 || config.slot > 9 {
+		log.Fatalln("invalid save state slot:", config.slot)
+	}
+
+	emu, err := newEmulator(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if config.movie != "" && config.saveMovie != "" {
+		log.Fatalln("-movie and -record-movie can't be used together")
+	}
+	if config.movie != "" {
+		if err := emu.playMovie(config.movie); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if config.saveMovie != "" {
+		if err := emu.startMovie(config.saveMovie, false); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	defer emu.stopMovie()
+
+	if config.record != "" {
+		emu.startRecording(config.record)
+	}
+	defer emu.stopRecording()
+
+	if config.headless {
+		emu.runHeadless()
+	} else {
+		emu.runWindow()
+	}
+}
+
+func newEmulator(romPath string) (*emulator, error) {
+	emu := &emulator{romPath: romPath, slot: config.slot}
+	emu.inputs[0], emu.inputs[1] = noInput, noInput
+
+	cart, err := nes.LoadROM(romPath)
+	if err != nil {
+		return nil, err
+	}
+
+	console := nes.NewConsole()
+	if err := console.LoadCartridge(cart); err != nil {
+		return nil, err
+	}
+
+	emu.console = console
+	emu.cart = cart
+
+	switch strings.ToLower(config.region) {
+	case "auto":
+	case "ntsc":
+		console.SetRegion(nes.RegionNTSC)
+	case "pal":
+		console.SetRegion(nes.RegionPAL)
+	case "dendy":
+		console.SetRegion(nes.RegionDendy)
+	default:
+		return nil, fmt.Errorf("unknown region: %s", config.region)
+	}
+
+	if config.palette != "" {
+		fp, err := os.Open(config.palette)
+		if err != nil {
+			return nil, err
+		}
+		colors, err := nes.ReadPalette(fp)
+		fp.Close()
+		if err != nil {
+			return nil, fmt.Errorf("palette %s: %v", config.palette, err)
+		}
+		console.SetPalette(colors)
+	}
+
+	emu.saveDir = config.saveDir
+	if emu.saveDir == "" {
+		emu.saveDir = filepath.Dir(romPath)
+	}
+	emu.savePath = filepath.Join(emu.saveDir, filepath.Base(nes.SRAMPath(romPath)))
+
+	// 从开机开始的输入录像不使用电池存档，也不要覆盖它
+	if config.movie != "" || config.saveMovie != "" {
+		emu.savePath = ""
+	}
+
+	if cart.Battery && emu.savePath != "" {
+		if err := cart.LoadSRAM(emu.savePath); err != nil {
+			log.Println("load sram:", err)
+		}
+	}
+
+	if config.opcodes {
+		console.SetTracer(os.Stdout)
+	}
+
+	if config.rewind > 0 {
+		emu.rewinder = nes.NewRewinder(console, config.rewindInterval, config.rewind)
+	}
+
+	return emu, nil
+}
+
+func (o *emulator) saveSRAM() {
+	if !o.cart.Battery || o.savePath == "" {
+		return
+	}
+	if err := o.cart.SaveSRAM(o.savePath); err != nil {
+		log.Println("save sram:", err)
+	}
+}
+
+// 即时存档的路径：存档目录下的 <rom>.st0 ~ <rom>.st9
+func (o *emulator) statePath() string {
+	base := filepath.Base(o.romPath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Join(o.saveDir, fmt.Sprintf("%s.st%d", base, o.slot))
+}
 
-	console := NewConsole()
-	cartridge := LoadROM("smb.nes")
-	console.Run(cartridge)
+func (o *emulator) saveState() {
+	path := o.statePath()
+	if err := o.console.SaveStateFile(path); err != nil {
+		log.Println("save state:", err)
+		return
+	}
+	log.Println("state saved to slot", o.slot)
+}
 
-	if err = sdl.Init(sdl.INIT_EVERYTHING); err != nil {
+func (o *emulator) loadState() {
+	path := o.statePath()
+	if err := o.console.LoadStateFile(path); err != nil {
+		log.Println("load state:", err)
+		return
+	}
+	if o.rewinder != nil {
+		o.rewinder.Reset()
+	}
+	log.Println("state loaded from slot", o.slot)
+}
+
+// 截图保存到存档目录：<rom>-20060102-150405.000.png
+func (o *emulator) screenshot() {
+	base := filepath.Base(o.romPath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := fmt.Sprintf("%s-%s.png", base, time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(o.saveDir, name)
+	if err := writePNG(path, o.console.Frame()); err != nil {
+		log.Println("screenshot:", err)
+		return
+	}
+	log.Println("screenshot saved to", path)
+}
+
+// 开始录像，声音的采样率和 -audio 一致
+func (o *emulator) startRecording(path string) {
+	if err := o.console.StartRecording(path, config.audioRate); err != nil {
+		log.Println("record:", err)
+		return
+	}
+	log.Println("recording to", path)
+}
+
+func (o *emulator) stopRecording() {
+	if !o.console.Recording() {
+		return
+	}
+	if err := o.console.StopRecording(); err != nil {
+		log.Println("record:", err)
+		return
+	}
+	log.Println("recording stopped")
+}
+
+// 录像保存到存档目录：<rom>-20060102-150405.000.avi
+func (o *emulator) toggleRecording() {
+	if o.console.Recording() {
+		o.stopRecording()
+		return
+	}
+	base := filepath.Base(o.romPath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := fmt.Sprintf("%s-%s.avi", base, time.Now().Format("20060102-150405.000"))
+	o.startRecording(filepath.Join(o.saveDir, name))
+}
+
+// CPU 停机时报告一次，返回是否处于停机状态
+func (o *emulator) checkHalted() bool {
+	err := o.console.Halted()
+	if err != nil && !o.halted {
+		log.Println(err)
+	}
+	o.halted = err != nil
+	return o.halted
+}
+
+// 没有窗口和声音，按实际帧率运行，直到被中断
+func (o *emulator) runHeadless() {
+	defer o.saveSRAM()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+
+	frame := time.NewTicker(time.Duration(float64(time.Second) / o.console.FrameRate()))
+	defer frame.Stop()
+
+	save := time.NewTicker(sramSaveInterval * time.Millisecond)
+	defer save.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-save.C:
+			o.saveSRAM()
+		case <-frame.C:
+			o.console.StepFrame()
+			if o.checkHalted() {
+				return
+			}
+			o.checkMovie()
+		}
+	}
+}
+
+func (o *emulator) runWindow() {
+	defer o.saveSRAM()
+
+	console := o.console
+
+	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		panic(err)
 	}
 
 	defer sdl.Quit()
 
-	window, err := sdl.CreateWindow("taones",
+	var audio *AudioOutput
+	if config.audioRate > 0 {
+		var err error
+		audio, err = NewAudioOutput(console.CPUFrequency(), config.audioRate)
+		if err != nil {
+			panic(err)
+		}
+		defer audio.Close()
+		console.SetSampler(audio.Sampler())
+	}
+
+	var flags uint32 = sdl.WINDOW_SHOWN
+	if config.fullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+
+	window, err := sdl.CreateWindow(fmt.Sprintf("taones - slot %d", o.slot),
 		sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED,
-		256*int32(config.scale), 240*int32(config.scale), sdl.WINDOW_SHOWN,
+		256*int32(config.scale), 240*int32(config.scale), flags,
 	)
 
 	if err != nil {
@@ -53,54 +377,114 @@ func main() {
 	}
 
 	bufPixels := buffer.Pixels()
-	console.ppu.SetBuffer(bufPixels)
+	console.SetBuffer(bufPixels)
 
-	var keys [8]bool
-	var turboA, turboB bool
+	var rewinding bool
+	var rewindTime float64 // 倒带时累计的时间（秒）
+
+	bindings, err := loadInputConfig(config.input)
+	if err != nil {
+		panic(err)
+	}
 
-	kbdCtrl1 := NewKeyboardController(func(frameCounter uint64) [8]bool {
-		var keys2 = keys
+	input := NewInput(bindings, config.input)
+	defer input.Close()
 
-		if frameCounter&3 == 0 {
-			keys2[ButtonA] = keys2[ButtonA] || turboA
-			keys2[ButtonB] = keys2[ButtonB] || turboB
-		}
+	for port := range o.inputs {
+		o.inputs[port] = input.Player(port)
+	}
 
-		return keys2
-	})
+	// 绑定按键时在标题栏显示提示
+	updateTitle := func() {
+		if prompt := input.BindPrompt(); prompt != "" {
+			window.SetTitle("taones - " + prompt)
+		} else {
+			window.SetTitle(fmt.Sprintf("taones - slot %d", o.slot))
+		}
+	}
 
-	console.SetController1(kbdCtrl1)
+	o.updateController()
 
 	var lastTime uint32
+	var lastSave uint32
 
 	var originRect = &sdl.Rect{0, 0, 256, 240}
-	var scaledRect = &sdl.Rect{0, 0, 256 * int32(config.scale), 240 * int32(config.scale)}
+	var scaledRect = fitRect(surface.W, surface.H)
+
+	if config.zapper {
+		cursor := sdl.CreateSystemCursor(sdl.SYSTEM_CURSOR_CROSSHAIR)
+		defer sdl.FreeCursor(cursor)
+		sdl.SetCursor(cursor)
+
+		// 鼠标瞄准，左键扣扳机
+		o.zapper = nes.NewZapper(console, func() (int, int, bool) {
+			mx, my, state := sdl.GetMouseState()
+			x, y := -1, -1
+			if sdl.GetMouseFocus() == window && mx >= scaledRect.X && my >= scaledRect.Y {
+				x = int((mx - scaledRect.X) * 256 / scaledRect.W)
+				y = int((my - scaledRect.Y) * 240 / scaledRect.H)
+			}
+			return x, y, state&sdl.ButtonLMask() != 0
+		})
+		o.updateController()
+	}
 
 	for run := true; run; {
-		switch evt := sdl.PollEvent().(type) {
+		event := sdl.PollEvent()
+		if input.HandleEvent(event) {
+			updateTitle()
+			event = nil
+		}
+
+		switch evt := event.(type) {
 		case *sdl.KeyboardEvent:
 			if evt.WindowID == wid {
 				switch evt.Keysym.Sym {
-				case sdl.K_w:
-					keys[ButtonUp] = evt.Type == sdl.KEYDOWN
-				case sdl.K_s:
-					keys[ButtonDown] = evt.Type == sdl.KEYDOWN
-				case sdl.K_a:
-					keys[ButtonLeft] = evt.Type == sdl.KEYDOWN
-				case sdl.K_d:
-					keys[ButtonRight] = evt.Type == sdl.KEYDOWN
-				case sdl.K_t:
-					keys[ButtonSelect] = evt.Type == sdl.KEYDOWN
-				case sdl.K_y:
-					keys[ButtonStart] = evt.Type == sdl.KEYDOWN
-				case sdl.K_j:
-					keys[ButtonB] = evt.Type == sdl.KEYDOWN
-				case sdl.K_k:
-					keys[ButtonA] = evt.Type == sdl.KEYDOWN
-				case sdl.K_u:
-					turboB = evt.Type == sdl.KEYDOWN
-				case sdl.K_i:
-					turboA = evt.Type == sdl.KEYDOWN
+				case sdl.K_BACKSPACE:
+					if evt.Repeat != 0 {
+						break
+					}
+					rewinding = evt.Type == sdl.KEYDOWN && o.rewinder != nil
+					rewindTime = 0
+					// 倒带时不出声
+					if audio != nil {
+						if rewinding {
+							console.SetSampler(nil)
+						} else {
+							console.SetSampler(audio.Sampler())
+						}
+					}
+				case sdl.K_F12:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						o.screenshot()
+					}
+				case sdl.K_F7:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						o.toggleMovie()
+					}
+				case sdl.K_F10:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						o.toggleRecording()
+					}
+				case sdl.K_F3, sdl.K_F4:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						input.StartBinding(int(evt.Keysym.Sym - sdl.K_F3))
+						updateTitle()
+					}
+				case sdl.K_F5:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						o.saveState()
+					}
+				case sdl.K_F9:
+					if evt.Type == sdl.KEYDOWN && evt.Repeat == 0 {
+						o.loadState()
+					}
+				case sdl.K_0, sdl.K_1, sdl.K_2, sdl.K_3, sdl.K_4,
+					sdl.K_5, sdl.K_6, sdl.K_7, sdl.K_8, sdl.K_9:
+					if evt.Type == sdl.KEYDOWN {
+						o.slot = int(evt.Keysym.Sym - sdl.K_0)
+						updateTitle()
+					}
 				}
 			}
 		case *sdl.QuitEvent:
@@ -114,10 +498,59 @@ func main() {
 		}
 		lastTime = ticks
 
-		console.StepSeconds(float64(diff) / 1000)
+		if rewinding {
+			// 每份存档隔了 interval 帧，过这么多帧的时间才倒退一次，
+			// 载入后预览一帧把画面画出来，预览的这一帧不会进入录像
+			rewindTime += float64(diff) / 1000
+			period := float64(o.rewinder.Interval()) / console.FrameRate()
+			for ; rewindTime >= period; rewindTime -= period {
+				ok, err := o.rewinder.Rewind()
+				if err != nil {
+					log.Println("rewind:", err)
+				}
+				if !ok {
+					rewindTime = 0
+					break
+				}
+				if err := console.PreviewFrame(); err != nil {
+					log.Println("rewind:", err)
+				}
+			}
+		} else {
+			console.StepSeconds(float64(diff) / 1000)
+			o.checkHalted()
+			o.checkMovie()
+			if o.rewinder != nil {
+				if err := o.rewinder.Err(); err != nil {
+					log.Println("rewind:", err)
+				}
+			}
+		}
+
+		if audio != nil {
+			audio.Flush()
+		}
+
+		if ticks-lastSave > sramSaveInterval {
+			o.saveSRAM()
+			lastSave = ticks
+		}
 
 		buffer.BlitScaled(originRect, surface, scaledRect)
 
 		window.UpdateSurface()
 	}
 }
+
+// 保持比例，把画面放到 w x h 的窗口中间
+func fitRect(w, h int32) *sdl.Rect {
+	scale := w / 256
+	if s := h / 240; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	sw, sh := 256*scale, 240*scale
+	return &sdl.Rect{(w - sw) / 2, (h - sh) / 2, sw, sh}
+}
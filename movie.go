@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/movsb/taones/nes"
+)
+
+// 一个玩家的输入：普通按键、按住的连发键和连发的节奏（帧数）
+type playerInput struct {
+	buttons func(frameCounter uint64) [8]bool
+	turbo   func(frameCounter uint64) [8]bool
+	on, off int
+}
+
+func noButtons(frameCounter uint64) [8]bool {
+	return [8]bool{}
+}
+
+// 没有窗口时不按任何键
+var noInput = playerInput{buttons: noButtons, turbo: noButtons, on: 1, off: 3}
+
+// 按当前的状态设置两个手柄：回放录像、边录边玩或者直接用键盘
+func (o *emulator) updateController() {
+	var ctrls [2]nes.ControllerProvider
+	for port, in := range o.inputs {
+		if o.player != nil {
+			ctrls[port] = o.player.Controller(port)
+			continue
+		}
+		// 录像不记录光枪
+		if port == 1 && o.zapper != nil {
+			ctrls[port] = o.zapper
+			continue
+		}
+		ctrl := nes.NewTurboController(nes.NewKeyboardController(in.buttons), in.turbo, in.on, in.off)
+		if o.movieRec != nil {
+			ctrls[port] = o.movieRec.Controller(port, ctrl)
+		} else {
+			ctrls[port] = ctrl
+		}
+	}
+	o.console.SetController1(ctrls[0])
+	o.console.SetController2(ctrls[1])
+}
+
+// 开始录制输入录像，fromState 为 false 时从开机开始
+func (o *emulator) startMovie(path string, fromState bool) error {
+	rec, err := nes.NewMovieRecorder(o.console, fromState)
+	if err != nil {
+		return err
+	}
+	o.movieRec = rec
+	o.moviePath = path
+	o.updateController()
+	log.Println("recording movie to", path)
+	return nil
+}
+
+// 结束录制并保存
+func (o *emulator) stopMovie() {
+	if o.movieRec == nil {
+		return
+	}
+
+	movie := o.movieRec.Stop()
+	o.movieRec = nil
+	o.updateController()
+
+	base := filepath.Base(o.romPath)
+	movie.ROMFilename = strings.TrimSuffix(base, filepath.Ext(base))
+
+	if err := movie.SaveFile(o.moviePath); err != nil {
+		log.Println("movie:", err)
+		return
+	}
+	log.Printf("movie saved to %s (%d frames)", o.moviePath, len(movie.Frames))
+}
+
+// 从当前状态开始录制，保存到存档目录：<rom>-20060102-150405.000.fm2
+func (o *emulator) toggleMovie() {
+	if o.movieRec != nil {
+		o.stopMovie()
+		return
+	}
+	if o.player != nil {
+		log.Println("movie: playing back")
+		return
+	}
+	base := filepath.Base(o.romPath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	name := fmt.Sprintf("%s-%s.fm2", base, time.Now().Format("20060102-150405.000"))
+	if err := o.startMovie(filepath.Join(o.saveDir, name), true); err != nil {
+		log.Println("movie:", err)
+	}
+}
+
+func (o *emulator) playMovie(path string) error {
+	movie, err := nes.LoadMovieFile(path)
+	if err != nil {
+		return err
+	}
+	player, err := nes.NewMoviePlayer(o.console, movie)
+	if err != nil {
+		return err
+	}
+	o.player = player
+	o.updateController()
+	log.Printf("playing movie %s (%d frames)", path, len(movie.Frames))
+	return nil
+}
+
+// 回放结束后检查是否同步，之后交还给键盘
+func (o *emulator) checkMovie() {
+	if o.player == nil || !o.player.Done() {
+		return
+	}
+
+	if err := o.player.Verify(); err != nil {
+		log.Println("movie:", err)
+	} else {
+		log.Println("movie finished, ram hash matches")
+	}
+
+	o.player = nil
+	o.updateController()
+}
@@ -0,0 +1,806 @@
+package nes
+
+import (
+	"encoding/gob"
+)
+
+/*
+ $4000~$4013, $4015, $4017
+
+ 5个通道：两个脉冲波、一个三角波、一个噪声、一个DPCM
+
+ https://wiki.nesdev.com/w/index.php/APU
+*/
+
+// 长度计数器加载表
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// 脉冲波占空比序列
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// 三角波序列
+var triangleTable = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// 噪声周期（CPU 周期）
+var (
+	noiseTable = [16]uint16{
+		4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+	}
+	noiseTablePAL = [16]uint16{
+		4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778,
+	}
+)
+
+// DMC 周期（CPU 周期）
+var (
+	dmcTable = [16]uint16{
+		428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+	}
+	dmcTablePAL = [16]uint16{
+		398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50,
+	}
+)
+
+// 非线性混音查找表
+var (
+	pulseMixTable [31]float32
+	tndMixTable   [203]float32
+)
+
+func init() {
+	for i := 1; i < len(pulseMixTable); i++ {
+		pulseMixTable[i] = 95.52 / (8128.0/float32(i) + 100)
+	}
+	for i := 1; i < len(tndMixTable); i++ {
+		tndMixTable[i] = 163.67 / (24329.0/float32(i) + 100)
+	}
+}
+
+// Sampler 接收 APU 的输出，每个 CPU 周期一个采样，范围 [0,1]
+type Sampler func(v float32)
+
+// 包络发生器
+type Envelope struct {
+	start    bool
+	loop     bool // 同时也是长度计数器暂停标志
+	constant bool
+	volume   byte // 常量音量或者分频周期
+	divider  byte
+	decay    byte
+}
+
+func (o *Envelope) write(v byte) {
+	o.loop = v>>5&1 == 1
+	o.constant = v>>4&1 == 1
+	o.volume = v & 0x0F
+}
+
+// 由帧计数器的 1/4 帧时钟驱动
+func (o *Envelope) clock() {
+	if o.start {
+		o.start = false
+		o.decay = 15
+		o.divider = o.volume
+		return
+	}
+	if o.divider > 0 {
+		o.divider--
+		return
+	}
+	o.divider = o.volume
+	if o.decay > 0 {
+		o.decay--
+	} else if o.loop {
+		o.decay = 15
+	}
+}
+
+func (o *Envelope) output() byte {
+	if o.constant {
+		return o.volume
+	}
+	return o.decay
+}
+
+// 脉冲波通道
+type Pulse struct {
+	channel byte // 1 或 2，扫描单元的取反方式不一样
+	enabled bool
+	Envelope
+
+	dutyMode  byte
+	dutyValue byte
+
+	timerPeriod uint16
+	timerValue  uint16
+
+	lengthValue byte
+
+	sweepEnabled bool
+	sweepPeriod  byte
+	sweepNegate  bool
+	sweepShift   byte
+	sweepReload  bool
+	sweepDivider byte
+}
+
+// $4000/$4004
+func (o *Pulse) writeControl(v byte) {
+	o.dutyMode = v >> 6 & 3
+	o.Envelope.write(v)
+}
+
+// $4001/$4005
+func (o *Pulse) writeSweep(v byte) {
+	o.sweepEnabled = v>>7&1 == 1
+	o.sweepPeriod = v >> 4 & 7
+	o.sweepNegate = v>>3&1 == 1
+	o.sweepShift = v & 7
+	o.sweepReload = true
+}
+
+// $4002/$4006
+func (o *Pulse) writeTimerLow(v byte) {
+	o.timerPeriod = o.timerPeriod&0xFF00 | uint16(v)
+}
+
+// $4003/$4007
+func (o *Pulse) writeTimerHigh(v byte) {
+	if o.enabled {
+		o.lengthValue = lengthTable[v>>3]
+	}
+	o.timerPeriod = o.timerPeriod&0x00FF | uint16(v&7)<<8
+	o.Envelope.start = true
+	o.dutyValue = 0
+}
+
+// 由 APU 周期（两个 CPU 周期）驱动
+func (o *Pulse) clockTimer() {
+	if o.timerValue == 0 {
+		o.timerValue = o.timerPeriod
+		o.dutyValue = (o.dutyValue + 1) & 7
+	} else {
+		o.timerValue--
+	}
+}
+
+func (o *Pulse) clockLength() {
+	if !o.loop && o.lengthValue > 0 {
+		o.lengthValue--
+	}
+}
+
+// 扫描单元的目标周期
+func (o *Pulse) sweepTarget() uint16 {
+	delta := o.timerPeriod >> o.sweepShift
+	if !o.sweepNegate {
+		return o.timerPeriod + delta
+	}
+	// 通道1用反码，通道2用补码
+	if o.channel == 1 {
+		delta++
+	}
+	if delta > o.timerPeriod {
+		return 0
+	}
+	return o.timerPeriod - delta
+}
+
+func (o *Pulse) sweepMuted() bool {
+	return o.timerPeriod < 8 || o.sweepTarget() > 0x7FF
+}
+
+func (o *Pulse) clockSweep() {
+	if o.sweepDivider == 0 && o.sweepEnabled && o.sweepShift > 0 && !o.sweepMuted() {
+		o.timerPeriod = o.sweepTarget()
+	}
+	if o.sweepDivider == 0 || o.sweepReload {
+		o.sweepDivider = o.sweepPeriod
+		o.sweepReload = false
+	} else {
+		o.sweepDivider--
+	}
+}
+
+func (o *Pulse) output() byte {
+	if !o.enabled || o.lengthValue == 0 || o.sweepMuted() {
+		return 0
+	}
+	if dutyTable[o.dutyMode][o.dutyValue] == 0 {
+		return 0
+	}
+	return o.Envelope.output()
+}
+
+// 三角波通道
+type Triangle struct {
+	enabled     bool
+	control     bool // 同时也是长度计数器暂停标志
+	counterLoad byte
+	counter     byte
+	reload      bool
+
+	timerPeriod uint16
+	timerValue  uint16
+
+	dutyValue   byte
+	lengthValue byte
+}
+
+// $4008
+func (o *Triangle) writeControl(v byte) {
+	o.control = v>>7&1 == 1
+	o.counterLoad = v & 0x7F
+}
+
+// $400A
+func (o *Triangle) writeTimerLow(v byte) {
+	o.timerPeriod = o.timerPeriod&0xFF00 | uint16(v)
+}
+
+// $400B
+func (o *Triangle) writeTimerHigh(v byte) {
+	if o.enabled {
+		o.lengthValue = lengthTable[v>>3]
+	}
+	o.timerPeriod = o.timerPeriod&0x00FF | uint16(v&7)<<8
+	o.reload = true
+}
+
+// 由 CPU 周期驱动
+func (o *Triangle) clockTimer() {
+	if o.timerValue == 0 {
+		o.timerValue = o.timerPeriod
+		if o.lengthValue > 0 && o.counter > 0 {
+			o.dutyValue = (o.dutyValue + 1) & 31
+		}
+	} else {
+		o.timerValue--
+	}
+}
+
+func (o *Triangle) clockCounter() {
+	if o.reload {
+		o.counter = o.counterLoad
+	} else if o.counter > 0 {
+		o.counter--
+	}
+	if !o.control {
+		o.reload = false
+	}
+}
+
+func (o *Triangle) clockLength() {
+	if !o.control && o.lengthValue > 0 {
+		o.lengthValue--
+	}
+}
+
+// 关闭时序列停在当前位置，输出保持不变
+func (o *Triangle) output() byte {
+	// 周期太小时是超声波，输出固定在中间电平以免出现爆音
+	if o.timerPeriod < 2 {
+		return 7
+	}
+	return triangleTable[o.dutyValue]
+}
+
+// 噪声通道
+type Noise struct {
+	enabled bool
+	Envelope
+
+	mode        bool
+	shift       uint16 // 15位线性反馈移位寄存器
+	periods     *[16]uint16
+	timerPeriod uint16
+	timerValue  uint16
+	lengthValue byte
+}
+
+// $400C
+func (o *Noise) writeControl(v byte) {
+	o.Envelope.write(v)
+}
+
+// $400E
+func (o *Noise) writePeriod(v byte) {
+	o.mode = v>>7&1 == 1
+	o.timerPeriod = o.periods[v&0x0F]
+}
+
+// $400F
+func (o *Noise) writeLength(v byte) {
+	if o.enabled {
+		o.lengthValue = lengthTable[v>>3]
+	}
+	o.Envelope.start = true
+}
+
+// 由 CPU 周期驱动
+func (o *Noise) clockTimer() {
+	if o.timerValue == 0 {
+		o.timerValue = o.timerPeriod
+		var bit uint16
+		if o.mode {
+			bit = 6
+		} else {
+			bit = 1
+		}
+		feedback := o.shift&1 ^ o.shift>>bit&1
+		o.shift >>= 1
+		o.shift |= feedback << 14
+	} else {
+		o.timerValue--
+	}
+}
+
+func (o *Noise) clockLength() {
+	if !o.loop && o.lengthValue > 0 {
+		o.lengthValue--
+	}
+}
+
+func (o *Noise) output() byte {
+	if !o.enabled || o.lengthValue == 0 || o.shift&1 == 1 {
+		return 0
+	}
+	return o.Envelope.output()
+}
+
+// DMC 通道（增量调制）
+type DMC struct {
+	console *Console
+	enabled bool
+
+	irq  bool // 播放结束时产生中断
+	loop bool
+
+	periods     *[16]uint16
+	timerPeriod uint16
+	timerValue  uint16
+
+	value byte // 7位输出电平
+
+	sampleAddress uint16
+	sampleLength  uint16
+
+	currentAddress uint16
+	currentLength  uint16
+
+	buffer      byte
+	bufferEmpty bool
+
+	shiftRegister byte
+	bitCount      byte
+	silence       bool
+
+	irqFlag bool
+}
+
+// $4010
+func (o *DMC) writeControl(v byte) {
+	o.irq = v>>7&1 == 1
+	o.loop = v>>6&1 == 1
+	o.timerPeriod = o.periods[v&0x0F]
+	if !o.irq {
+		o.irqFlag = false
+	}
+}
+
+// $4011
+func (o *DMC) writeValue(v byte) {
+	o.value = v & 0x7F
+}
+
+// $4012：地址 = $C000 + A * 64
+func (o *DMC) writeAddress(v byte) {
+	o.sampleAddress = 0xC000 | uint16(v)<<6
+}
+
+// $4013：长度 = L * 16 + 1
+func (o *DMC) writeLength(v byte) {
+	o.sampleLength = uint16(v)<<4 | 1
+}
+
+func (o *DMC) restart() {
+	o.currentAddress = o.sampleAddress
+	o.currentLength = o.sampleLength
+}
+
+// 通过 CPU 总线（最终是 mapper）抓取一个采样字节
+func (o *DMC) fetch() {
+	if !o.bufferEmpty || o.currentLength == 0 {
+		return
+	}
+
+	cpu := o.console.cpu
+	cpu.suspendCycles += 4
+
+	o.buffer = cpu.Read(o.currentAddress)
+	o.bufferEmpty = false
+
+	if o.currentAddress++; o.currentAddress == 0 {
+		o.currentAddress = 0x8000
+	}
+
+	if o.currentLength--; o.currentLength == 0 {
+		if o.loop {
+			o.restart()
+		} else if o.irq {
+			o.irqFlag = true
+		}
+	}
+}
+
+// 由 CPU 周期驱动
+func (o *DMC) clockTimer() {
+	o.fetch()
+
+	if o.timerValue > 0 {
+		o.timerValue--
+		return
+	}
+	o.timerValue = o.timerPeriod - 1
+
+	if !o.silence {
+		if o.shiftRegister&1 == 1 {
+			if o.value <= 125 {
+				o.value += 2
+			}
+		} else {
+			if o.value >= 2 {
+				o.value -= 2
+			}
+		}
+	}
+	o.shiftRegister >>= 1
+
+	if o.bitCount > 0 {
+		o.bitCount--
+	}
+	if o.bitCount == 0 {
+		o.bitCount = 8
+		if o.bufferEmpty {
+			o.silence = true
+		} else {
+			o.silence = false
+			o.shiftRegister = o.buffer
+			o.bufferEmpty = true
+		}
+	}
+}
+
+func (o *DMC) output() byte {
+	return o.value
+}
+
+type APU struct {
+	console *Console
+	sampler Sampler
+
+	pulse1   Pulse
+	pulse2   Pulse
+	triangle Triangle
+	noise    Noise
+	dmc      DMC
+
+	frameSteps *[7]uint32 // 帧计数器的步进点，随制式变化
+	cycle      uint64     // CPU 周期计数，偶数周期驱动 APU 周期
+	frameCycle uint32     // 帧计数器内的 CPU 周期
+	frameMode  byte       // 0: 4步，1: 5步
+	frameIRQ   bool       // 帧中断使能（$4017 第6位取反）
+	irqFlag    bool       // 帧中断标志
+}
+
+func NewAPU(console *Console) *APU {
+	apu := &APU{console: console}
+	apu.pulse1.channel = 1
+	apu.pulse2.channel = 2
+	apu.dmc.console = console
+	apu.setTiming(&timings[RegionNTSC])
+	apu.Reset()
+	return apu
+}
+
+// 需要保存到即时存档的字段（指针）
+func (o *Envelope) fields() []interface{} {
+	return []interface{}{
+		&o.start, &o.loop, &o.constant, &o.volume, &o.divider, &o.decay,
+	}
+}
+
+func (o *Pulse) fields() []interface{} {
+	return append(o.Envelope.fields(),
+		&o.enabled, &o.dutyMode, &o.dutyValue,
+		&o.timerPeriod, &o.timerValue, &o.lengthValue,
+		&o.sweepEnabled, &o.sweepPeriod, &o.sweepNegate,
+		&o.sweepShift, &o.sweepReload, &o.sweepDivider,
+	)
+}
+
+func (o *Triangle) fields() []interface{} {
+	return []interface{}{
+		&o.enabled, &o.control, &o.counterLoad, &o.counter, &o.reload,
+		&o.timerPeriod, &o.timerValue, &o.dutyValue, &o.lengthValue,
+	}
+}
+
+func (o *Noise) fields() []interface{} {
+	return append(o.Envelope.fields(),
+		&o.enabled, &o.mode, &o.shift,
+		&o.timerPeriod, &o.timerValue, &o.lengthValue,
+	)
+}
+
+func (o *DMC) fields() []interface{} {
+	return []interface{}{
+		&o.enabled, &o.irq, &o.loop, &o.timerPeriod, &o.timerValue, &o.value,
+		&o.sampleAddress, &o.sampleLength, &o.currentAddress, &o.currentLength,
+		&o.buffer, &o.bufferEmpty, &o.shiftRegister, &o.bitCount, &o.silence,
+		&o.irqFlag,
+	}
+}
+
+func (o *APU) fields() []interface{} {
+	var fields []interface{}
+	fields = append(fields, o.pulse1.fields()...)
+	fields = append(fields, o.pulse2.fields()...)
+	fields = append(fields, o.triangle.fields()...)
+	fields = append(fields, o.noise.fields()...)
+	fields = append(fields, o.dmc.fields()...)
+	fields = append(fields, &o.cycle, &o.frameCycle, &o.frameMode, &o.frameIRQ, &o.irqFlag)
+	return fields
+}
+
+func (o *APU) Save(enc *gob.Encoder) error {
+	return encodeAll(enc, o.fields()...)
+}
+
+func (o *APU) Load(dec *gob.Decoder) error {
+	return decodeAll(dec, o.fields()...)
+}
+
+func (o *APU) setTiming(t *timing) {
+	o.noise.periods = t.noise
+	o.dmc.periods = t.dmc
+	o.frameSteps = &t.frameSteps
+}
+
+func (o *APU) SetSampler(sampler Sampler) {
+	o.sampler = sampler
+}
+
+func (o *APU) Reset() {
+	o.writeControl(0)
+	o.writeFrameCounter(0)
+	o.noise.shift = 1
+	o.dmc.bufferEmpty = true
+	o.dmc.bitCount = 8
+	o.dmc.timerPeriod = o.dmc.periods[0]
+}
+
+func (o *APU) readRegister(a uint16) byte {
+	switch a {
+	case 0x4015:
+		return o.readStatus()
+	}
+	return 0
+}
+
+func (o *APU) writeRegister(a uint16, v byte) {
+	switch a {
+	case 0x4000:
+		o.pulse1.writeControl(v)
+	case 0x4001:
+		o.pulse1.writeSweep(v)
+	case 0x4002:
+		o.pulse1.writeTimerLow(v)
+	case 0x4003:
+		o.pulse1.writeTimerHigh(v)
+	case 0x4004:
+		o.pulse2.writeControl(v)
+	case 0x4005:
+		o.pulse2.writeSweep(v)
+	case 0x4006:
+		o.pulse2.writeTimerLow(v)
+	case 0x4007:
+		o.pulse2.writeTimerHigh(v)
+	case 0x4008:
+		o.triangle.writeControl(v)
+	case 0x400A:
+		o.triangle.writeTimerLow(v)
+	case 0x400B:
+		o.triangle.writeTimerHigh(v)
+	case 0x400C:
+		o.noise.writeControl(v)
+	case 0x400E:
+		o.noise.writePeriod(v)
+	case 0x400F:
+		o.noise.writeLength(v)
+	case 0x4010:
+		o.dmc.writeControl(v)
+	case 0x4011:
+		o.dmc.writeValue(v)
+	case 0x4012:
+		o.dmc.writeAddress(v)
+	case 0x4013:
+		o.dmc.writeLength(v)
+	case 0x4015:
+		o.writeControl(v)
+	case 0x4017:
+		o.writeFrameCounter(v)
+	}
+}
+
+// 读 $4015
+// IF-D NT21
+func (o *APU) readStatus() byte {
+	var v byte
+	if o.pulse1.lengthValue > 0 {
+		v |= 0x01
+	}
+	if o.pulse2.lengthValue > 0 {
+		v |= 0x02
+	}
+	if o.triangle.lengthValue > 0 {
+		v |= 0x04
+	}
+	if o.noise.lengthValue > 0 {
+		v |= 0x08
+	}
+	if o.dmc.currentLength > 0 {
+		v |= 0x10
+	}
+	if o.irqFlag {
+		v |= 0x40
+	}
+	if o.dmc.irqFlag {
+		v |= 0x80
+	}
+	// 读取会清除帧中断标志
+	o.irqFlag = false
+	return v
+}
+
+// 写 $4015
+// ---D NT21
+func (o *APU) writeControl(v byte) {
+	o.pulse1.enabled = v&0x01 != 0
+	o.pulse2.enabled = v&0x02 != 0
+	o.triangle.enabled = v&0x04 != 0
+	o.noise.enabled = v&0x08 != 0
+	o.dmc.enabled = v&0x10 != 0
+
+	if !o.pulse1.enabled {
+		o.pulse1.lengthValue = 0
+	}
+	if !o.pulse2.enabled {
+		o.pulse2.lengthValue = 0
+	}
+	if !o.triangle.enabled {
+		o.triangle.lengthValue = 0
+	}
+	if !o.noise.enabled {
+		o.noise.lengthValue = 0
+	}
+	if !o.dmc.enabled {
+		o.dmc.currentLength = 0
+	} else if o.dmc.currentLength == 0 {
+		o.dmc.restart()
+	}
+
+	o.dmc.irqFlag = false
+}
+
+// 写 $4017
+// MI-- ----
+func (o *APU) writeFrameCounter(v byte) {
+	o.frameMode = v >> 7 & 1
+	o.frameIRQ = v>>6&1 == 0
+	if !o.frameIRQ {
+		o.irqFlag = false
+	}
+	o.frameCycle = 0
+	// 5步模式下写入时会立即产生一次 1/4 帧和 1/2 帧时钟
+	if o.frameMode == 1 {
+		o.clockQuarterFrame()
+		o.clockHalfFrame()
+	}
+}
+
+// 包络和三角波线性计数器
+func (o *APU) clockQuarterFrame() {
+	o.pulse1.Envelope.clock()
+	o.pulse2.Envelope.clock()
+	o.triangle.clockCounter()
+	o.noise.Envelope.clock()
+}
+
+// 长度计数器和扫描单元
+func (o *APU) clockHalfFrame() {
+	o.pulse1.clockLength()
+	o.pulse2.clockLength()
+	o.triangle.clockLength()
+	o.noise.clockLength()
+	o.pulse1.clockSweep()
+	o.pulse2.clockSweep()
+}
+
+// 帧计数器
+// 4步模式：1/4 帧时钟在第 1、2、3、4 步，1/2 帧时钟在第 2、4 步，第 4 步产生中断
+// 5步模式：1/4 帧时钟在第 1、2、3、5 步，1/2 帧时钟在第 2、5 步，不产生中断
+func (o *APU) clockFrameCounter() {
+	o.frameCycle++
+
+	steps := o.frameSteps
+
+	switch o.frameCycle {
+	case steps[0], steps[2]:
+		o.clockQuarterFrame()
+	case steps[1]:
+		o.clockQuarterFrame()
+		o.clockHalfFrame()
+	case steps[3]:
+		if o.frameMode == 0 {
+			o.clockQuarterFrame()
+			o.clockHalfFrame()
+			if o.frameIRQ {
+				o.irqFlag = true
+			}
+		}
+	case steps[4]:
+		if o.frameMode == 0 {
+			o.frameCycle = 0
+		}
+	case steps[5]:
+		o.clockQuarterFrame()
+		o.clockHalfFrame()
+	case steps[6]:
+		o.frameCycle = 0
+	}
+}
+
+// 混音输出
+func (o *APU) output() float32 {
+	p1 := o.pulse1.output()
+	p2 := o.pulse2.output()
+	t := o.triangle.output()
+	n := o.noise.output()
+	d := o.dmc.output()
+	return pulseMixTable[p1+p2] + tndMixTable[3*t+2*n+d]
+}
+
+// APU 步进一个 CPU 周期
+func (o *APU) Step() {
+	o.cycle++
+
+	o.clockFrameCounter()
+
+	if o.cycle&1 == 0 {
+		o.pulse1.clockTimer()
+		o.pulse2.clockTimer()
+	}
+	o.triangle.clockTimer()
+	o.noise.clockTimer()
+	o.dmc.clockTimer()
+
+	if o.irqFlag || o.dmc.irqFlag {
+		o.console.cpu.triggerIRQ()
+	}
+
+	if o.sampler != nil {
+		o.sampler(o.output())
+	}
+}
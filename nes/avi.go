@@ -0,0 +1,492 @@
+package nes
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math"
+)
+
+/*
+ 无压缩的 AVI 文件（OpenDML 扩展，可以超过 1GB）
+
+ RIFF 'AVI '
+   LIST 'hdrl'
+     avih
+     LIST 'strl' strh strf indx   视频：24 位 BGR，从下往上
+     LIST 'strl' strh strf indx   音频：16 位单声道 PCM（可选）
+     LIST 'odml' dmlh
+   LIST 'movi' 00db 01wb 00db 01wb ... ix00 ix01
+   idx1
+ RIFF 'AVIX'
+   LIST 'movi' 00db 01wb ... ix00 ix01
+ ...
+
+ 每个 RIFF 不超过 aviSegmentSize，各自带一份标准索引（ix00/ix01），
+ indx 超级索引指向所有的标准索引。idx1 只覆盖第一个 RIFF，给老的播放器用。
+
+ https://www.alexander-noe.com/video/documentation/avi.pdf
+*/
+
+const (
+	aviSegmentSize  = 1 << 30 // 每个 RIFF 的大小上限
+	aviSuperEntries = 256     // 超级索引预留的条目数，最多 256 个 RIFF
+	aviSuperSize    = 24 + 16*aviSuperEntries
+
+	aviKeyFrame = 0x10 // idx1 里的 AVIIF_KEYFRAME
+)
+
+var ErrAVITooLarge = errors.New("nes: avi file too large")
+var errAVIClosed = errors.New("nes: avi writer closed")
+
+// 一个流的索引信息
+type aviStream struct {
+	id       string // 00db / 01wb
+	indxPos  int64  // indx 数据开始的位置
+	lengthAt int64  // strh.dwLength 的位置
+	super    []aviSuperEntry
+	entries  []aviEntry // 当前 RIFF 的块
+	length   uint32     // 总长度（帧数或者采样数）
+	duration uint32     // 当前 RIFF 中的长度
+}
+
+type aviSuperEntry struct {
+	offset   int64
+	size     uint32
+	duration uint32
+}
+
+type aviEntry struct {
+	offset int64 // 块数据的绝对位置
+	size   uint32
+}
+
+// AVIWriter 写无压缩的 AVI 文件
+type AVIWriter struct {
+	w   io.WriteSeeker
+	pos int64
+	err error
+
+	width, height int
+	sampleRate    int
+
+	video aviStream
+	audio *aviStream
+
+	riffPos int64 // 当前 RIFF 大小字段的位置
+	moviPos int64 // 当前 movi 大小字段的位置
+	first   bool  // 是否是第一个 RIFF
+
+	idx1 []aviEntry // 第一个 RIFF 的全部块，按顺序
+	ids  []byte     // idx1 中每个块属于哪个流：0 视频，1 音频
+
+	avihFramesAt int64 // avih.dwTotalFrames 的位置
+	dmlhFramesAt int64 // dmlh.dwTotalFrames 的位置
+
+	frame []byte
+}
+
+// NewAVIWriter 写入文件头，sampleRate 为 0 时没有音轨
+// fps 是帧率，比如 NTSC 的 60.0988
+func NewAVIWriter(w io.WriteSeeker, width, height int, fps float64, sampleRate int) (*AVIWriter, error) {
+	o := &AVIWriter{
+		w:          w,
+		width:      width,
+		height:     height,
+		sampleRate: sampleRate,
+		video:      aviStream{id: "00db"},
+		first:      true,
+		frame:      make([]byte, width*height*3),
+	}
+	if sampleRate > 0 {
+		o.audio = &aviStream{id: "01wb"}
+	}
+
+	o.writeHeader(fps)
+	o.beginMovi()
+
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	return o, nil
+}
+
+func (o *AVIWriter) write(p []byte) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.w.Write(p)
+	o.pos += int64(n)
+	o.err = err
+}
+
+func (o *AVIWriter) fourcc(s string) {
+	o.write([]byte(s))
+}
+
+func (o *AVIWriter) u16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	o.write(b[:])
+}
+
+func (o *AVIWriter) u32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	o.write(b[:])
+}
+
+func (o *AVIWriter) u64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	o.write(b[:])
+}
+
+// 回到 at 处写一个 uint32，然后回到文件末尾
+func (o *AVIWriter) patch32(at int64, v uint32) {
+	o.seek(at)
+	o.u32(v)
+	o.seek(-1)
+}
+
+// at 为 -1 时回到文件末尾
+func (o *AVIWriter) seek(at int64) {
+	if o.err != nil {
+		return
+	}
+	if at < 0 {
+		o.pos, o.err = o.w.Seek(0, io.SeekEnd)
+	} else {
+		o.pos, o.err = o.w.Seek(at, io.SeekStart)
+	}
+}
+
+// 开始一个 LIST 或者 RIFF，返回大小字段的位置
+func (o *AVIWriter) beginList(kind, name string) int64 {
+	o.fourcc(kind)
+	at := o.pos
+	o.u32(0)
+	o.fourcc(name)
+	return at
+}
+
+// 结束 LIST 或者 RIFF，回填大小
+func (o *AVIWriter) endList(at int64) {
+	o.patch32(at, uint32(o.pos-at-4))
+}
+
+func (o *AVIWriter) writeHeader(fps float64) {
+	streams := uint32(1)
+	if o.audio != nil {
+		streams++
+	}
+
+	frameSize := uint32(o.width * o.height * 3)
+
+	// 帧率表示成 rate/scale
+	const scale = 10000
+	rate := uint32(math.Round(fps * scale))
+
+	o.riffPos = o.beginList("RIFF", "AVI ")
+	hdrl := o.beginList("LIST", "hdrl")
+
+	o.fourcc("avih")
+	o.u32(56)
+	o.u32(uint32(math.Round(1e6 / fps))) // dwMicroSecPerFrame
+	o.u32(uint32(math.Ceil(fps)) * (frameSize + uint32(o.sampleRate*2)))
+	o.u32(0)            // dwPaddingGranularity
+	o.u32(0x10 | 0x100) // AVIF_HASINDEX | AVIF_ISINTERLEAVED
+	o.avihFramesAt = o.pos
+	o.u32(0)         // dwTotalFrames，第一个 RIFF 中的帧数
+	o.u32(0)         // dwInitialFrames
+	o.u32(streams)   // dwStreams
+	o.u32(frameSize) // dwSuggestedBufferSize
+	o.u32(uint32(o.width))
+	o.u32(uint32(o.height))
+	o.write(make([]byte, 16))
+
+	// 视频流
+	strl := o.beginList("LIST", "strl")
+	o.fourcc("strh")
+	o.u32(56)
+	o.fourcc("vids")
+	o.u32(0) // fccHandler：无压缩
+	o.u32(0) // dwFlags
+	o.u16(0) // wPriority
+	o.u16(0) // wLanguage
+	o.u32(0) // dwInitialFrames
+	o.u32(scale)
+	o.u32(rate)
+	o.u32(0) // dwStart
+	o.video.lengthAt = o.pos
+	o.u32(0) // dwLength
+	o.u32(frameSize)
+	o.u32(0xFFFFFFFF) // dwQuality
+	o.u32(0)          // dwSampleSize
+	o.u16(0)
+	o.u16(0)
+	o.u16(uint16(o.width))
+	o.u16(uint16(o.height))
+
+	// BITMAPINFOHEADER
+	o.fourcc("strf")
+	o.u32(40)
+	o.u32(40)
+	o.u32(uint32(o.width))
+	o.u32(uint32(o.height)) // 正数表示从下往上
+	o.u16(1)                // biPlanes
+	o.u16(24)               // biBitCount
+	o.u32(0)                // BI_RGB
+	o.u32(frameSize)
+	o.u32(0)
+	o.u32(0)
+	o.u32(0)
+	o.u32(0)
+
+	o.writeSuperIndex(&o.video)
+	o.endList(strl)
+
+	// 音频流
+	if o.audio != nil {
+		strl := o.beginList("LIST", "strl")
+		o.fourcc("strh")
+		o.u32(56)
+		o.fourcc("auds")
+		o.u32(0)
+		o.u32(0)
+		o.u16(0)
+		o.u16(0)
+		o.u32(0)
+		o.u32(1)                    // dwScale
+		o.u32(uint32(o.sampleRate)) // dwRate
+		o.u32(0)
+		o.audio.lengthAt = o.pos
+		o.u32(0) // dwLength，采样数
+		o.u32(uint32(o.sampleRate * 2))
+		o.u32(0xFFFFFFFF)
+		o.u32(2) // dwSampleSize
+		o.write(make([]byte, 8))
+
+		// PCMWAVEFORMAT
+		o.fourcc("strf")
+		o.u32(16)
+		o.u16(1) // WAVE_FORMAT_PCM
+		o.u16(1) // 单声道
+		o.u32(uint32(o.sampleRate))
+		o.u32(uint32(o.sampleRate * 2))
+		o.u16(2)
+		o.u16(16)
+
+		o.writeSuperIndex(o.audio)
+		o.endList(strl)
+	}
+
+	odml := o.beginList("LIST", "odml")
+	o.fourcc("dmlh")
+	o.u32(248)
+	o.dmlhFramesAt = o.pos
+	o.u32(0)
+	o.write(make([]byte, 244))
+	o.endList(odml)
+
+	o.endList(hdrl)
+}
+
+// 超级索引先占好位置，结束时回填
+func (o *AVIWriter) writeSuperIndex(s *aviStream) {
+	o.fourcc("indx")
+	o.u32(aviSuperSize)
+	s.indxPos = o.pos
+	o.write(make([]byte, aviSuperSize))
+}
+
+func (o *AVIWriter) beginMovi() {
+	o.moviPos = o.beginList("LIST", "movi")
+}
+
+// 写一个数据块，按需要开始新的 RIFF
+func (o *AVIWriter) chunk(s *aviStream, data []byte, duration uint32) {
+	if o.pos+int64(len(data))+1024 > o.riffPos+aviSegmentSize {
+		if len(o.video.super) >= aviSuperEntries-1 {
+			o.err = ErrAVITooLarge
+			return
+		}
+		o.nextSegment()
+	}
+
+	o.fourcc(s.id)
+	o.u32(uint32(len(data)))
+
+	s.entries = append(s.entries, aviEntry{offset: o.pos, size: uint32(len(data))})
+	s.length += duration
+	s.duration += duration
+
+	if o.first {
+		o.idx1 = append(o.idx1, aviEntry{offset: o.pos - 8, size: uint32(len(data))})
+		if s == &o.video {
+			o.ids = append(o.ids, 0)
+		} else {
+			o.ids = append(o.ids, 1)
+		}
+	}
+
+	o.write(data)
+	if len(data)&1 != 0 {
+		o.write([]byte{0})
+	}
+}
+
+// WriteFrame 写入一帧画面，img 的大小必须和创建时一致
+func (o *AVIWriter) WriteFrame(img *image.RGBA) error {
+	if o.err != nil {
+		return o.err
+	}
+
+	// 从下往上，BGR
+	stride := o.width * 3
+	for y := 0; y < o.height; y++ {
+		p := img.Pix[y*img.Stride:]
+		row := o.frame[(o.height-1-y)*stride:]
+		for x := 0; x < o.width; x++ {
+			row[x*3+0] = p[x*4+2]
+			row[x*3+1] = p[x*4+1]
+			row[x*3+2] = p[x*4+0]
+		}
+	}
+
+	o.chunk(&o.video, o.frame, 1)
+
+	return o.err
+}
+
+// WriteAudio 写入 16 位的 PCM 采样
+func (o *AVIWriter) WriteAudio(samples []int16) error {
+	if o.err != nil || o.audio == nil || len(samples) == 0 {
+		return o.err
+	}
+
+	data := make([]byte, len(samples)*2)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(s))
+	}
+
+	o.chunk(o.audio, data, uint32(len(samples)))
+
+	return o.err
+}
+
+// 写当前 RIFF 的标准索引，并记录到超级索引中
+func (o *AVIWriter) writeStdIndex(s *aviStream) {
+	if len(s.entries) == 0 {
+		return
+	}
+
+	base := s.entries[0].offset
+	at := o.pos
+
+	o.fourcc("ix" + s.id[:2])
+	o.u32(uint32(24 + 8*len(s.entries)))
+	o.u16(2) // wLongsPerEntry
+	o.write([]byte{0, 1})
+	o.u32(uint32(len(s.entries)))
+	o.fourcc(s.id)
+	o.u64(uint64(base))
+	o.u32(0)
+	for _, e := range s.entries {
+		o.u32(uint32(e.offset - base))
+		o.u32(e.size)
+	}
+
+	s.super = append(s.super, aviSuperEntry{
+		offset:   at,
+		size:     uint32(o.pos - at),
+		duration: s.duration,
+	})
+	s.entries = s.entries[:0]
+	s.duration = 0
+}
+
+// 结束当前的 RIFF
+func (o *AVIWriter) endSegment() {
+	o.writeStdIndex(&o.video)
+	if o.audio != nil {
+		o.writeStdIndex(o.audio)
+	}
+	o.endList(o.moviPos)
+
+	if o.first {
+		o.writeIdx1()
+		o.patch32(o.avihFramesAt, o.video.length)
+		o.first = false
+	}
+
+	o.endList(o.riffPos)
+}
+
+func (o *AVIWriter) nextSegment() {
+	o.endSegment()
+	o.riffPos = o.beginList("RIFF", "AVIX")
+	o.beginMovi()
+}
+
+// idx1 中的偏移相对于 movi 的 fourcc
+func (o *AVIWriter) writeIdx1() {
+	movi := o.moviPos + 4
+	o.fourcc("idx1")
+	o.u32(uint32(16 * len(o.idx1)))
+	for i, e := range o.idx1 {
+		if o.ids[i] == 0 {
+			o.fourcc(o.video.id)
+		} else {
+			o.fourcc(o.audio.id)
+		}
+		o.u32(aviKeyFrame)
+		o.u32(uint32(e.offset - movi))
+		o.u32(e.size)
+	}
+	o.idx1 = nil
+	o.ids = nil
+}
+
+// 回填超级索引
+func (o *AVIWriter) patchSuperIndex(s *aviStream) {
+	o.seek(s.indxPos)
+	o.u16(4) // wLongsPerEntry
+	o.write([]byte{0, 0})
+	o.u32(uint32(len(s.super)))
+	o.fourcc(s.id)
+	o.write(make([]byte, 12))
+	for _, e := range s.super {
+		o.u64(uint64(e.offset))
+		o.u32(e.size)
+		o.u32(e.duration)
+	}
+	o.seek(-1)
+}
+
+// Close 写入索引并回填文件头，不会关闭底层的文件
+func (o *AVIWriter) Close() error {
+	if o.err != nil {
+		return o.err
+	}
+
+	o.endSegment()
+
+	o.patchSuperIndex(&o.video)
+	o.patch32(o.video.lengthAt, o.video.length)
+	if o.audio != nil {
+		o.patchSuperIndex(o.audio)
+		o.patch32(o.audio.lengthAt, o.audio.length)
+	}
+	o.patch32(o.dmlhFramesAt, o.video.length)
+
+	if o.err != nil {
+		return o.err
+	}
+
+	// 之后再写都会出错
+	o.err = errAVIClosed
+	return nil
+}
@@ -0,0 +1,140 @@
+package nes
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// RIFF 文件里的一个块，RIFF 和 LIST 的 id 写成 "LIST:hdrl" 这样
+type aviChunk struct {
+	id       string
+	pos      int64 // 块头在文件中的位置
+	data     []byte
+	children []aviChunk
+}
+
+func parseAVIChunks(t *testing.T, data []byte, pos int64) []aviChunk {
+	t.Helper()
+	var chunks []aviChunk
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated chunk at %d", pos)
+		}
+		id := string(data[:4])
+		size := int64(binary.LittleEndian.Uint32(data[4:]))
+		if size > int64(len(data)-8) {
+			t.Fatalf("chunk %s at %d: size %d out of range", id, pos, size)
+		}
+		c := aviChunk{id: id, pos: pos, data: data[8 : 8+size]}
+		if id == "RIFF" || id == "LIST" {
+			c.id += ":" + string(c.data[:4])
+			c.children = parseAVIChunks(t, c.data[4:], pos+12)
+		}
+		chunks = append(chunks, c)
+
+		size += size & 1
+		data = data[8+size:]
+		pos += 8 + size
+	}
+	return chunks
+}
+
+func aviChunkIDs(chunks []aviChunk) []string {
+	var ids []string
+	for _, c := range chunks {
+		ids = append(ids, c.id)
+	}
+	return ids
+}
+
+// 录几帧到文件，再按块结构解析回来
+func TestAVIRecording(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		strl       int
+	}{
+		{"video", 0, 1},
+		{"video and audio", 44100, 2},
+	}
+
+	const frames = 5
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.avi")
+			console := testConsole(t)
+			console.StepFrame()
+			if err := console.StartRecording(path, tt.sampleRate); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < frames; i++ {
+				console.StepFrame()
+			}
+			if err := console.StopRecording(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			top := parseAVIChunks(t, data, 0)
+			if ids := aviChunkIDs(top); !reflect.DeepEqual(ids, []string{"RIFF:AVI "}) {
+				t.Fatalf("top level = %q", ids)
+			}
+			riff := top[0].children
+			if ids := aviChunkIDs(riff); !reflect.DeepEqual(ids, []string{"LIST:hdrl", "LIST:movi", "idx1"}) {
+				t.Fatalf("RIFF = %q", ids)
+			}
+
+			hdrl := riff[0].children
+			want := []string{"avih"}
+			for i := 0; i < tt.strl; i++ {
+				want = append(want, "LIST:strl")
+			}
+			want = append(want, "LIST:odml")
+			if ids := aviChunkIDs(hdrl); !reflect.DeepEqual(ids, want) {
+				t.Fatalf("hdrl = %q, want %q", ids, want)
+			}
+			for _, strl := range hdrl[1 : 1+tt.strl] {
+				if ids := aviChunkIDs(strl.children); !reflect.DeepEqual(ids, []string{"strh", "strf", "indx"}) {
+					t.Fatalf("strl = %q", ids)
+				}
+			}
+			if n := binary.LittleEndian.Uint32(hdrl[0].data[16:]); n != frames {
+				t.Errorf("avih total frames = %d, want %d", n, frames)
+			}
+
+			// movi 里是交错的数据块，最后是每个流的标准索引
+			movi := riff[1]
+			count := map[string]int{}
+			for _, c := range movi.children {
+				count[c.id]++
+			}
+			if count["00db"] != frames || count["ix00"] != 1 {
+				t.Errorf("movi = %v, want %d video chunks", count, frames)
+			}
+			if tt.sampleRate > 0 && (count["01wb"] == 0 || count["ix01"] != 1) {
+				t.Errorf("movi = %v, want audio chunks", count)
+			}
+
+			// idx1 按顺序列出 movi 里所有的数据块，偏移相对于 movi 的 fourcc
+			idx1 := riff[2].data
+			if n := len(idx1) / 16; n != count["00db"]+count["01wb"] {
+				t.Fatalf("idx1 entries = %d, want %d", n, count["00db"]+count["01wb"])
+			}
+			for i := 0; i < len(idx1); i += 16 {
+				id := string(idx1[i : i+4])
+				at := movi.pos + 8 + int64(binary.LittleEndian.Uint32(idx1[i+8:]))
+				if string(data[at:at+4]) != id {
+					t.Fatalf("idx1 entry %d: %q at %d, found %q", i/16, id, at, data[at:at+4])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package nes
+
+import (
+	"encoding/gob"
+)
+
+// CPU/PPU 时序
+const (
+	TimingNTSC  = 0
+	TimingPAL   = 1
+	TimingMulti = 2 // 多区域
+	TimingDendy = 3
+)
+
+// 主机类型
+const (
+	ConsoleNES        = 0 // NES/Famicom
+	ConsoleVs         = 1 // Vs. System
+	ConsolePlayChoice = 2 // PlayChoice-10
+	ConsoleExtended   = 3 // 见 ExtendedConsole
+)
+
+const (
+	defaultSRAMSize   = 8192
+	defaultCHRRAMSize = 8192
+	trainerSize       = 512
+	trainerOffset     = 0x1000 // 训练器在 SRAM 中的位置，即 $7000
+)
+
+type Cartridge struct {
+	PRG    []byte
+	CHR    []byte
+	SRAM   []byte // $6000-$7FFF
+	Mapper uint16
+	Mirror byte
+
+	Battery bool // SRAM 有电池，需要持久化
+
+	// 以下信息来自 NES 2.0 文件头，iNES 1.0 时尽量推断
+	NES20           bool
+	Submapper       byte
+	PRGRAMSize      int // 易失的 PRG-RAM 字节数
+	PRGNVRAMSize    int // 有电池的 PRG-RAM 字节数
+	CHRRAMSize      int // 易失的 CHR-RAM 字节数
+	CHRNVRAMSize    int // 有电池的 CHR-RAM 字节数
+	Timing          byte
+	ConsoleType     byte
+	VsPPUType       byte // ConsoleType 为 ConsoleVs 时有效
+	VsHardwareType  byte // ConsoleType 为 ConsoleVs 时有效
+	ExtendedConsole byte // ConsoleType 为 ConsoleExtended 时有效
+	MiscROMs        byte
+	ExpansionDevice byte // 默认扩展设备
+	Trainer         []byte
+
+	chrRAM    bool   // CHR 是 RAM，需要保存到即时存档中
+	sramSaved []byte // 最后一次加载/保存时的 SRAM，用于判断是否需要写盘
+}
+
+func NewCartridge(prg []byte, chr []byte, mapper uint16, mirror byte) *Cartridge {
+	return &Cartridge{
+		PRG:    prg,
+		CHR:    chr,
+		SRAM:   make([]byte, defaultSRAMSize),
+		Mapper: mapper,
+		Mirror: mirror,
+	}
+}
+
+func (o *Cartridge) Save(enc *gob.Encoder) error {
+	if err := encodeAll(enc, o.SRAM); err != nil {
+		return err
+	}
+	if o.chrRAM {
+		return encodeAll(enc, o.CHR)
+	}
+	return nil
+}
+
+func (o *Cartridge) Load(dec *gob.Decoder) error {
+	if err := decodeAll(dec, &o.SRAM); err != nil {
+		return err
+	}
+	if o.chrRAM {
+		return decodeAll(dec, &o.CHR)
+	}
+	return nil
+}
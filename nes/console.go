@@ -0,0 +1,245 @@
+package nes
+
+import (
+	"bytes"
+	"image"
+	"io"
+)
+
+type Console struct {
+	cpu    *CPU
+	ppu    *PPU
+	apu    *APU
+	cart   *Cartridge
+	mapper Mapper
+	ctrl1  ControllerProvider
+	ctrl2  ControllerProvider
+
+	sampler       Sampler
+	frameCallback FrameCallback
+	recorder      *Recorder // 正在录像时不为空
+	movie         movieHook // 正在录制或者回放输入录像时不为空
+	rewinder      *Rewinder // 开启倒带时不为空
+	frameEnded    bool      // 当前指令执行中完成了一帧
+
+	watcher PPUAddressWatcher // mapper 实现了的话不为空
+
+	region    Region
+	timing    *timing
+	ppuCredit int // 不是整数倍时，累计的 PPU 周期（以 ppuDen 为单位）
+}
+
+func NewConsole() *Console {
+	console := &Console{}
+	console.cpu = NewCPU(console)
+	console.ppu = NewPPU(console)
+	console.apu = NewAPU(console)
+	console.ctrl1 = &EmptyController{}
+	console.ctrl2 = &EmptyController{}
+	console.SetRegion(RegionNTSC)
+	return console
+}
+
+// SetRegion 设置主机制式，LoadCartridge 会根据卡带自动设置一次
+func (o *Console) SetRegion(region Region) {
+	o.region = region
+	o.timing = &timings[region]
+	o.ppu.setTiming(o.timing)
+	o.apu.setTiming(o.timing)
+}
+
+func (o *Console) Region() Region {
+	return o.region
+}
+
+// CPUFrequency 返回当前制式下的 CPU 频率
+func (o *Console) CPUFrequency() float64 {
+	return o.timing.cpuFreq
+}
+
+// FrameRate 返回当前制式下的帧率
+func (o *Console) FrameRate() float64 {
+	return o.timing.frameRate
+}
+
+// SetPalette 设置调色板颜色，见 ReadPalette
+func (o *Console) SetPalette(colors [64]uint) {
+	o.ppu.colors = colors
+}
+
+// 新手柄接着旧手柄移位寄存器的状态，
+// 这样中途换手柄（开始录像、回放）不会打乱游戏正在进行的读取
+func (o *Console) SetController1(controller ControllerProvider) {
+	setControllerState(controller, controllerState(o.ctrl1))
+	o.ctrl1 = controller
+}
+
+func (o *Console) SetController2(controller ControllerProvider) {
+	setControllerState(controller, controllerState(o.ctrl2))
+	o.ctrl2 = controller
+}
+
+// SetBuffer 设置 256x240 的 32 位像素缓冲区，PPU 直接往里面画
+func (o *Console) SetBuffer(buf []byte) {
+	o.ppu.SetBuffer(buf)
+}
+
+// SetPixeler 设置逐像素的回调（未设置缓冲区时使用）
+func (o *Console) SetPixeler(pixeler Pixeler) {
+	o.ppu.SetPixeler(pixeler)
+}
+
+// Buffer 返回当前的像素缓冲区
+func (o *Console) Buffer() []byte {
+	return o.ppu.buffer
+}
+
+// Frame 返回当前画面的拷贝，在 StepFrame 之后调用得到的是完整的一帧
+func (o *Console) Frame() *image.RGBA {
+	img := image.NewRGBA(o.ppu.picture.Rect)
+	copy(img.Pix, o.ppu.picture.Pix)
+	return img
+}
+
+// SetSampler 设置音频采样回调，每个 CPU 周期调用一次
+func (o *Console) SetSampler(sampler Sampler) {
+	o.sampler = sampler
+	o.updateSampler()
+}
+
+// 录像时 APU 的输出同时给录像和外部
+func (o *Console) updateSampler() {
+	switch {
+	case o.recorder == nil:
+		o.apu.SetSampler(o.sampler)
+	case o.sampler == nil:
+		o.apu.SetSampler(o.recorder.sample)
+	default:
+		sampler, recorder := o.sampler, o.recorder
+		o.apu.SetSampler(func(v float32) {
+			sampler(v)
+			recorder.sample(v)
+		})
+	}
+}
+
+// SetFrameCallback 设置帧完成回调
+// 回调发生在指令执行的中途，不能在里面单步执行或者保存状态
+func (o *Console) SetFrameCallback(callback FrameCallback) {
+	o.frameCallback = callback
+}
+
+// PPU 完成一帧时调用
+func (o *Console) endFrame() {
+	o.frameEnded = true
+	if o.recorder != nil {
+		o.recorder.frame(o.ppu.picture)
+	}
+	if o.movie != nil {
+		o.movie.endFrame()
+	}
+	if o.frameCallback != nil {
+		o.frameCallback(o.ppu.FrameCount)
+	}
+}
+
+// FrameCount 返回已经完成的帧数
+func (o *Console) FrameCount() uint64 {
+	return o.ppu.FrameCount
+}
+
+// SetTracer 设置指令跟踪输出，nil 表示关闭
+func (o *Console) SetTracer(w io.Writer) {
+	o.cpu.tracer = w
+}
+
+// Step 执行一条指令（或者一个中断、一个 DMA 周期），返回消耗的 CPU 周期数
+// PPU 和 APU 在 CPU 的每次总线访问时同步前进，见 clock
+func (o *Console) Step() int {
+	cycles := o.cpu.Step()
+	// 帧完成时还在指令中间，等指令执行完再保存倒带存档
+	if o.frameEnded {
+		o.frameEnded = false
+		if o.rewinder != nil {
+			o.rewinder.capture()
+		}
+	}
+	return cycles
+}
+
+// 运行一个 CPU 周期对应的 PPU、mapper 和 APU
+func (o *Console) clock() {
+	o.ppuCredit += o.timing.ppuNum
+	for o.ppuCredit >= o.timing.ppuDen {
+		o.ppuCredit -= o.timing.ppuDen
+		o.ppu.Step()
+		o.mapper.Step()
+	}
+	o.apu.Step()
+}
+
+// StepFrame 运行到 PPU 完成当前帧，返回消耗的 CPU 周期数
+// 只在指令之间停下，所以会多执行完帧结束时正在执行的那条指令；
+// 结果只取决于主机状态，和真实时间无关
+func (o *Console) StepFrame() int {
+	cycles := 0
+	frame := o.ppu.FrameCount
+	for frame == o.ppu.FrameCount {
+		cycles += o.Step()
+	}
+	return cycles
+}
+
+// PreviewFrame 运行一帧把画面画出来，然后恢复到运行之前的状态
+// 这一帧不出声，也不进入 AVI 录像、输入录像、倒带和帧回调，比如倒带时用来显示画面
+func (o *Console) PreviewFrame() error {
+	var state bytes.Buffer
+	if err := o.SaveState(&state); err != nil {
+		return err
+	}
+
+	sampler, recorder, movie, rewinder, callback := o.sampler, o.recorder, o.movie, o.rewinder, o.frameCallback
+	o.sampler, o.recorder, o.movie, o.rewinder, o.frameCallback = nil, nil, nil, nil, nil
+	o.updateSampler()
+
+	o.StepFrame()
+
+	o.sampler, o.recorder, o.movie, o.rewinder, o.frameCallback = sampler, recorder, movie, rewinder, callback
+	o.updateSampler()
+
+	return o.LoadState(&state)
+}
+
+func (o *Console) StepSeconds(s float64) {
+	cycles := int(o.timing.cpuFreq * s)
+	for cycles > 0 {
+		cycles -= o.Step()
+	}
+}
+
+// Halted 返回 CPU 停机的原因（*HaltError），正常运行时返回 nil
+func (o *Console) Halted() error {
+	return o.cpu.Halted()
+}
+
+func (o *Console) Reset() {
+	o.cpu.Reset()
+	o.apu.Reset()
+}
+
+// LoadCartridge 插入卡带并复位
+func (o *Console) LoadCartridge(cart *Cartridge) error {
+	mapper, err := NewMapper(o, cart)
+	if err != nil {
+		return err
+	}
+
+	o.cart = cart
+	o.mapper = mapper
+	o.watcher, _ = o.mapper.(PPUAddressWatcher)
+
+	o.SetRegion(cart.Region())
+	o.Reset()
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package nes
+
+const (
+	ButtonA = iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+// 读手柄时没有驱动的数据线（高 3 位）保持总线上的值，即地址的高字节 $40
+const controllerOpenBus = 0x40
+
+/*
+ 标准手柄是一个 8 位的移位寄存器：
+
+ 写 $4016 第 0 位为 1（选通）时持续锁存按键，读到的总是 A；
+ 变为 0 之后每读一次移出一位，顺序是 A B Select Start 上 下 左 右，
+ 8 位读完之后一直读到 1。
+
+ https://wiki.nesdev.com/w/index.php/Standard_controller
+*/
+
+type ControllerProvider interface {
+	// Strobe 写 $4016 时调用，on 是第 0 位
+	Strobe(on bool, frameCounter uint64)
+	// Read 读 $4016/$4017，bus 是总线上残留的值，返回整个字节
+	Read(bus byte) byte
+	// Shift 每次读之后调用，移到下一位
+	Shift()
+}
+
+// LatchedController 能报告锁存的按键的手柄，录像时需要
+type LatchedController interface {
+	ControllerProvider
+	// Latched 返回最近一次选通时锁存的按键
+	Latched() [8]bool
+	// State 和 SetState 读取和恢复移位寄存器，用于即时存档
+	State() ControllerState
+	SetState(s ControllerState)
+}
+
+// ControllerState 是标准手柄移位寄存器的状态
+// 和手柄的具体实现无关，所以录像时的键盘和回放时的录像手柄可以互相接续
+type ControllerState struct {
+	Buttons [8]bool // 锁存的按键
+	Index   byte    // 下一次读出第几位
+	Strobe  bool
+}
+
+// 没有移位寄存器的手柄（光枪等）返回空的状态
+func controllerState(ctrl ControllerProvider) ControllerState {
+	if c, ok := ctrl.(LatchedController); ok {
+		return c.State()
+	}
+	return ControllerState{}
+}
+
+func setControllerState(ctrl ControllerProvider, s ControllerState) {
+	if c, ok := ctrl.(LatchedController); ok {
+		c.SetState(s)
+	}
+}
+
+// 没有插手柄：数据线都是 0
+type EmptyController struct {
+}
+
+func (o *EmptyController) Strobe(on bool, frameCounter uint64) {
+
+}
+
+func (o *EmptyController) Read(bus byte) byte {
+	return bus & 0xE0
+}
+
+func (o *EmptyController) Shift() {
+
+}
+
+type KeyboardController struct {
+	buttons [8]bool
+	index   byte
+	strobe  bool
+	flusher func(frameCounter uint64) [8]bool
+}
+
+// flusher 在选通时调用，返回这一帧的按键
+func NewKeyboardController(flusher func(frameCounter uint64) [8]bool) *KeyboardController {
+	return &KeyboardController{
+		flusher: flusher,
+	}
+}
+
+func (o *KeyboardController) Strobe(on bool, frameCounter uint64) {
+	o.strobe = on
+	if on {
+		if o.flusher != nil {
+			o.buttons = o.flusher(frameCounter)
+		}
+		o.index = 0
+	}
+}
+
+func (o *KeyboardController) Read(bus byte) byte {
+	d := byte(1) // 读完 8 位之后是 1
+	if o.index < 8 && !o.buttons[o.index] {
+		d = 0
+	}
+	return bus&0xE0 | d
+}
+
+func (o *KeyboardController) Latched() [8]bool {
+	return o.buttons
+}
+
+func (o *KeyboardController) Shift() {
+	if !o.strobe && o.index < 8 {
+		o.index++
+	}
+}
+
+func (o *KeyboardController) State() ControllerState {
+	return ControllerState{Buttons: o.buttons, Index: o.index, Strobe: o.strobe}
+}
+
+func (o *KeyboardController) SetState(s ControllerState) {
+	o.buttons, o.index, o.strobe = s.Buttons, s.Index, s.Strobe
+}
@@ -0,0 +1,1450 @@
+package nes
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 寻址模式（Addressing Modes）
+const (
+	_                 byte = iota
+	amImmediate            // 1  立即
+	amZero                 // 2  零页索引
+	amZeroX                // 3  零页直接
+	amZeroY                // 4  零页直接
+	amAbsolute             // 5  绝对
+	amAbsoluteX            // 6  绝对X
+	amAbsoluteY            // 7  绝对Y
+	amIndirect             // 8  间接
+	amIndexedIndirect      // 9  先索引后间接
+	amIndirectIndexed      // 10 先间接后索引
+	amRelative             // 11 相对
+	amImplied              // 12 隐含
+	amAccumulator          // 13 累加器
+)
+
+// 指令访问操作数的方式，决定了变址寻址时是否总有一次哑读
+const (
+	accessRead  = iota // 只读，跨页时才多一次哑读
+	accessWrite        // 只写
+	accessRMW          // 读-改-写，会把原值先写回一次
+)
+
+// 中断模式
+const (
+	intNone = iota
+	intNMI
+	intIRQ
+)
+
+// HaltError 表示 CPU 执行了 KIL 指令而停机
+type HaltError struct {
+	PC     uint16
+	Opcode byte
+}
+
+func (e *HaltError) Error() string {
+	return fmt.Sprintf("nes: cpu halted by opcode $%02X at $%04X", e.Opcode, e.PC)
+}
+
+type OpCode struct {
+	code   byte
+	name   string
+	size   byte
+	cycles byte
+	paged  byte
+	mode   byte
+}
+
+var opcodesTable = [...]OpCode{
+	// adc
+	{0x69, "ADC", 2, 2, 0, amImmediate},
+	{0x65, "ADC", 2, 3, 0, amZero},
+	{0x75, "ADC", 2, 4, 0, amZeroX},
+	{0x6D, "ADC", 3, 4, 0, amAbsolute},
+	{0x7D, "ADC", 3, 4, 1, amAbsoluteX},
+	{0x79, "ADC", 3, 4, 1, amAbsoluteY},
+	{0x61, "ADC", 2, 6, 0, amIndexedIndirect},
+	{0x71, "ADC", 2, 5, 1, amIndirectIndexed},
+
+	// and
+	{0x29, "AND", 2, 2, 0, amImmediate},
+	{0x25, "AND", 2, 3, 0, amZero},
+	{0x35, "AND", 2, 4, 0, amZeroX},
+	{0x2D, "AND", 3, 4, 0, amAbsolute},
+	{0x3D, "AND", 3, 4, 1, amAbsoluteX},
+	{0x39, "AND", 3, 4, 1, amAbsoluteY},
+	{0x21, "AND", 2, 6, 0, amIndexedIndirect},
+	{0x31, "AND", 2, 5, 1, amIndirectIndexed},
+
+	// asl
+	{0x0A, "ASL", 1, 2, 0, amAccumulator},
+	{0x06, "ASL", 2, 5, 0, amZero},
+	{0x16, "ASL", 2, 6, 0, amZeroX},
+	{0x0E, "ASL", 3, 6, 0, amAbsolute},
+	{0x1E, "ASL", 3, 7, 0, amAbsoluteX},
+
+	{0x90, "BCC", 2, 2, 0, amRelative},
+	{0xB0, "BCS", 2, 2, 0, amRelative},
+	{0xF0, "BEQ", 2, 2, 0, amRelative},
+	{0x24, "BIT", 2, 3, 0, amZero},
+	{0x2C, "BIT", 3, 4, 0, amAbsolute},
+	{0x30, "BMI", 2, 2, 0, amRelative},
+	{0xD0, "BNE", 2, 2, 0, amRelative},
+	{0x10, "BPL", 2, 2, 0, amRelative},
+
+	{0x00, "BRK", 1, 7, 0, amImplied},
+
+	{0x50, "BVC", 2, 2, 0, amRelative},
+	{0x70, "BVS", 2, 2, 0, amRelative},
+
+	{0x18, "CLC", 1, 2, 0, amImplied},
+	{0xD8, "CLD", 1, 2, 0, amImplied},
+	{0x58, "CLI", 1, 2, 0, amImplied},
+	{0xB8, "CLV", 1, 2, 0, amImplied},
+
+	// cmp
+	{0xC9, "CMP", 2, 2, 0, amImmediate},
+	{0xC5, "CMP", 2, 3, 0, amZero},
+	{0xD5, "CMP", 2, 4, 0, amZeroX},
+	{0xCD, "CMP", 3, 4, 0, amAbsolute},
+	{0xDD, "CMP", 3, 4, 1, amAbsoluteX},
+	{0xD9, "CMP", 3, 4, 1, amAbsoluteY},
+	{0xC1, "CMP", 2, 6, 0, amIndexedIndirect},
+	{0xD1, "CMP", 2, 5, 1, amIndirectIndexed},
+
+	{0xE0, "CPX", 2, 2, 0, amImmediate},
+	{0xE4, "CPX", 2, 3, 0, amZero},
+	{0xEC, "CPX", 3, 4, 0, amAbsolute},
+
+	{0xC0, "CPY", 2, 2, 0, amImmediate},
+	{0xC4, "CPY", 2, 3, 0, amZero},
+	{0xCC, "CPY", 3, 4, 0, amAbsolute},
+
+	{0xC6, "DEC", 2, 5, 0, amZero},
+	{0xD6, "DEC", 2, 6, 0, amZeroX},
+	{0xCE, "DEC", 3, 6, 0, amAbsolute},
+	{0xDE, "DEC", 3, 7, 0, amAbsoluteX},
+
+	{0xCA, "DEX", 1, 2, 0, amImplied},
+	{0x88, "DEY", 1, 2, 0, amImplied},
+
+	{0x49, "EOR", 2, 2, 0, amImmediate},
+	{0x45, "EOR", 2, 3, 0, amZero},
+	{0x55, "EOR", 2, 4, 0, amZeroX},
+	{0x4D, "EOR", 3, 4, 0, amAbsolute},
+	{0x5D, "EOR", 3, 4, 1, amAbsoluteX},
+	{0x59, "EOR", 3, 4, 1, amAbsoluteY},
+	{0x41, "EOR", 2, 6, 0, amIndexedIndirect},
+	{0x51, "EOR", 2, 5, 1, amIndirectIndexed},
+
+	{0xE6, "INC", 2, 5, 0, amZero},
+	{0xF6, "INC", 2, 6, 0, amZeroX},
+	{0xEE, "INC", 3, 6, 0, amAbsolute},
+	{0xFE, "INC", 3, 7, 0, amAbsoluteX},
+
+	{0xE8, "INX", 1, 2, 0, amImplied},
+	{0xC8, "INY", 1, 2, 0, amImplied},
+
+	{0x4C, "JMP", 3, 3, 0, amAbsolute},
+	{0x6C, "JMP", 3, 5, 0, amIndirect},
+
+	{0x20, "JSR", 3, 6, 0, amAbsolute},
+
+	{0xA9, "LDA", 2, 2, 0, amImmediate},
+	{0xA5, "LDA", 2, 3, 0, amZero},
+	{0xB5, "LDA", 2, 4, 0, amZeroX},
+	{0xAD, "LDA", 3, 4, 0, amAbsolute},
+	{0xBD, "LDA", 3, 4, 1, amAbsoluteX},
+	{0xB9, "LDA", 3, 4, 1, amAbsoluteY},
+	{0xA1, "LDA", 2, 6, 0, amIndexedIndirect},
+	{0xB1, "LDA", 2, 5, 1, amIndirectIndexed},
+
+	{0xA2, "LDX", 2, 2, 0, amImmediate},
+	{0xA6, "LDX", 2, 3, 0, amZero},
+	{0xB6, "LDX", 2, 4, 0, amZeroY},
+	{0xAE, "LDX", 3, 4, 0, amAbsolute},
+	{0xBE, "LDX", 3, 4, 1, amAbsoluteY},
+
+	{0xA0, "LDY", 2, 2, 0, amImmediate},
+	{0xA4, "LDY", 2, 3, 0, amZero},
+	{0xB4, "LDY", 2, 4, 0, amZeroX},
+	{0xAC, "LDY", 3, 4, 0, amAbsolute},
+	{0xBC, "LDY", 3, 4, 1, amAbsoluteX},
+
+	{0x4A, "LSR", 1, 2, 0, amAccumulator},
+	{0x46, "LSR", 2, 5, 0, amZero},
+	{0x56, "LSR", 2, 6, 0, amZeroX},
+	{0x4E, "LSR", 3, 6, 0, amAbsolute},
+	{0x5E, "LSR", 3, 7, 0, amAbsoluteX},
+
+	{0xEA, "NOP", 1, 2, 0, amImplied},
+
+	{0x09, "ORA", 2, 2, 0, amImmediate},
+	{0x05, "ORA", 2, 3, 0, amZero},
+	{0x15, "ORA", 2, 4, 0, amZeroX},
+	{0x0D, "ORA", 3, 4, 0, amAbsolute},
+	{0x1D, "ORA", 3, 4, 1, amAbsoluteX},
+	{0x19, "ORA", 3, 4, 1, amAbsoluteY},
+	{0x01, "ORA", 2, 6, 0, amIndexedIndirect},
+	{0x11, "ORA", 2, 5, 1, amIndirectIndexed},
+
+	{0x48, "PHA", 1, 3, 0, amImplied},
+	{0x08, "PHP", 1, 3, 0, amImplied},
+	{0x68, "PLA", 1, 4, 0, amImplied},
+	{0x28, "PLP", 1, 4, 0, amImplied},
+
+	{0x2A, "ROL", 1, 2, 0, amAccumulator},
+	{0x26, "ROL", 2, 5, 0, amZero},
+	{0x36, "ROL", 2, 6, 0, amZeroX},
+	{0x2E, "ROL", 3, 6, 0, amAbsolute},
+	{0x3E, "ROL", 3, 7, 0, amAbsoluteX},
+
+	{0x6A, "ROR", 1, 2, 0, amAccumulator},
+	{0x66, "ROR", 2, 5, 0, amZero},
+	{0x76, "ROR", 2, 6, 0, amZeroX},
+	{0x6E, "ROR", 3, 6, 0, amAbsolute},
+	{0x7E, "ROR", 3, 7, 0, amAbsoluteX},
+
+	{0x40, "RTI", 1, 6, 0, amImplied},
+	{0x60, "RTS", 1, 6, 0, amImplied},
+
+	{0xE9, "SBC", 2, 2, 0, amImmediate},
+	{0xE5, "SBC", 2, 3, 0, amZero},
+	{0xF5, "SBC", 2, 4, 0, amZeroX},
+	{0xED, "SBC", 3, 4, 0, amAbsolute},
+	{0xFD, "SBC", 3, 4, 1, amAbsoluteX},
+	{0xF9, "SBC", 3, 4, 1, amAbsoluteY},
+	{0xE1, "SBC", 2, 6, 0, amIndexedIndirect},
+	{0xF1, "SBC", 2, 5, 1, amIndirectIndexed},
+
+	{0x38, "SEC", 1, 2, 0, amImplied},
+	{0xF8, "SED", 1, 2, 0, amImplied},
+	{0x78, "SEI", 1, 2, 0, amImplied},
+
+	{0x85, "STA", 2, 3, 0, amZero},
+	{0x95, "STA", 2, 4, 0, amZeroX},
+	{0x8D, "STA", 3, 4, 0, amAbsolute},
+	{0x9D, "STA", 3, 5, 0, amAbsoluteX},
+	{0x99, "STA", 3, 5, 0, amAbsoluteY},
+	{0x81, "STA", 2, 6, 0, amIndexedIndirect},
+	{0x91, "STA", 2, 6, 0, amIndirectIndexed},
+
+	{0x86, "STX", 2, 3, 0, amZero},
+	{0x96, "STX", 2, 4, 0, amZeroY},
+	{0x8E, "STX", 3, 4, 0, amAbsolute},
+
+	{0x84, "STY", 2, 3, 0, amZero},
+	{0x94, "STY", 2, 4, 0, amZeroX},
+	{0x8C, "STY", 3, 4, 0, amAbsolute},
+
+	{0xAA, "TAX", 1, 2, 0, amImplied},
+	{0xA8, "TAY", 1, 2, 0, amImplied},
+	{0xBA, "TSX", 1, 2, 0, amImplied},
+	{0x8A, "TXA", 1, 2, 0, amImplied},
+	{0x9A, "TXS", 1, 2, 0, amImplied},
+	{0x98, "TYA", 1, 2, 0, amImplied},
+}
+
+// 非官方指令
+// http://www.oxyron.de/html/opcodes02.html
+// https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+var unofficialOpcodesTable = [...]OpCode{
+	{0x1A, "NOP", 1, 2, 0, amImplied},
+	{0x3A, "NOP", 1, 2, 0, amImplied},
+	{0x5A, "NOP", 1, 2, 0, amImplied},
+	{0x7A, "NOP", 1, 2, 0, amImplied},
+	{0xDA, "NOP", 1, 2, 0, amImplied},
+	{0xFA, "NOP", 1, 2, 0, amImplied},
+	{0x80, "NOP", 2, 2, 0, amImmediate},
+	{0x82, "NOP", 2, 2, 0, amImmediate},
+	{0x89, "NOP", 2, 2, 0, amImmediate},
+	{0xC2, "NOP", 2, 2, 0, amImmediate},
+	{0xE2, "NOP", 2, 2, 0, amImmediate},
+	{0x04, "NOP", 2, 3, 0, amZero},
+	{0x44, "NOP", 2, 3, 0, amZero},
+	{0x64, "NOP", 2, 3, 0, amZero},
+	{0x14, "NOP", 2, 4, 0, amZeroX},
+	{0x34, "NOP", 2, 4, 0, amZeroX},
+	{0x54, "NOP", 2, 4, 0, amZeroX},
+	{0x74, "NOP", 2, 4, 0, amZeroX},
+	{0xD4, "NOP", 2, 4, 0, amZeroX},
+	{0xF4, "NOP", 2, 4, 0, amZeroX},
+	{0x0C, "NOP", 3, 4, 0, amAbsolute},
+	{0x1C, "NOP", 3, 4, 1, amAbsoluteX},
+	{0x3C, "NOP", 3, 4, 1, amAbsoluteX},
+	{0x5C, "NOP", 3, 4, 1, amAbsoluteX},
+	{0x7C, "NOP", 3, 4, 1, amAbsoluteX},
+	{0xDC, "NOP", 3, 4, 1, amAbsoluteX},
+	{0xFC, "NOP", 3, 4, 1, amAbsoluteX},
+
+	// lax = lda + ldx
+	{0xA7, "LAX", 2, 3, 0, amZero},
+	{0xB7, "LAX", 2, 4, 0, amZeroY},
+	{0xAF, "LAX", 3, 4, 0, amAbsolute},
+	{0xBF, "LAX", 3, 4, 1, amAbsoluteY},
+	{0xA3, "LAX", 2, 6, 0, amIndexedIndirect},
+	{0xB3, "LAX", 2, 5, 1, amIndirectIndexed},
+
+	// sax: M = A & X
+	{0x87, "SAX", 2, 3, 0, amZero},
+	{0x97, "SAX", 2, 4, 0, amZeroY},
+	{0x8F, "SAX", 3, 4, 0, amAbsolute},
+	{0x83, "SAX", 2, 6, 0, amIndexedIndirect},
+
+	{0xEB, "SBC", 2, 2, 0, amImmediate},
+
+	// dcp = dec + cmp
+	{0xC7, "DCP", 2, 5, 0, amZero},
+	{0xD7, "DCP", 2, 6, 0, amZeroX},
+	{0xCF, "DCP", 3, 6, 0, amAbsolute},
+	{0xDF, "DCP", 3, 7, 0, amAbsoluteX},
+	{0xDB, "DCP", 3, 7, 0, amAbsoluteY},
+	{0xC3, "DCP", 2, 8, 0, amIndexedIndirect},
+	{0xD3, "DCP", 2, 8, 0, amIndirectIndexed},
+
+	// isb = inc + sbc
+	{0xE7, "ISB", 2, 5, 0, amZero},
+	{0xF7, "ISB", 2, 6, 0, amZeroX},
+	{0xEF, "ISB", 3, 6, 0, amAbsolute},
+	{0xFF, "ISB", 3, 7, 0, amAbsoluteX},
+	{0xFB, "ISB", 3, 7, 0, amAbsoluteY},
+	{0xE3, "ISB", 2, 8, 0, amIndexedIndirect},
+	{0xF3, "ISB", 2, 8, 0, amIndirectIndexed},
+
+	// slo = asl + ora
+	{0x07, "SLO", 2, 5, 0, amZero},
+	{0x17, "SLO", 2, 6, 0, amZeroX},
+	{0x0F, "SLO", 3, 6, 0, amAbsolute},
+	{0x1F, "SLO", 3, 7, 0, amAbsoluteX},
+	{0x1B, "SLO", 3, 7, 0, amAbsoluteY},
+	{0x03, "SLO", 2, 8, 0, amIndexedIndirect},
+	{0x13, "SLO", 2, 8, 0, amIndirectIndexed},
+
+	// rla = rol + and
+	{0x27, "RLA", 2, 5, 0, amZero},
+	{0x37, "RLA", 2, 6, 0, amZeroX},
+	{0x2F, "RLA", 3, 6, 0, amAbsolute},
+	{0x3F, "RLA", 3, 7, 0, amAbsoluteX},
+	{0x3B, "RLA", 3, 7, 0, amAbsoluteY},
+	{0x23, "RLA", 2, 8, 0, amIndexedIndirect},
+	{0x33, "RLA", 2, 8, 0, amIndirectIndexed},
+
+	// sre = lsr + eor
+	{0x47, "SRE", 2, 5, 0, amZero},
+	{0x57, "SRE", 2, 6, 0, amZeroX},
+	{0x4F, "SRE", 3, 6, 0, amAbsolute},
+	{0x5F, "SRE", 3, 7, 0, amAbsoluteX},
+	{0x5B, "SRE", 3, 7, 0, amAbsoluteY},
+	{0x43, "SRE", 2, 8, 0, amIndexedIndirect},
+	{0x53, "SRE", 2, 8, 0, amIndirectIndexed},
+
+	// rra = ror + adc
+	{0x67, "RRA", 2, 5, 0, amZero},
+	{0x77, "RRA", 2, 6, 0, amZeroX},
+	{0x6F, "RRA", 3, 6, 0, amAbsolute},
+	{0x7F, "RRA", 3, 7, 0, amAbsoluteX},
+	{0x7B, "RRA", 3, 7, 0, amAbsoluteY},
+	{0x63, "RRA", 2, 8, 0, amIndexedIndirect},
+	{0x73, "RRA", 2, 8, 0, amIndirectIndexed},
+
+	{0x0B, "ANC", 2, 2, 0, amImmediate},
+	{0x2B, "ANC", 2, 2, 0, amImmediate},
+	{0x4B, "ALR", 2, 2, 0, amImmediate},
+	{0x6B, "ARR", 2, 2, 0, amImmediate},
+	{0xCB, "AXS", 2, 2, 0, amImmediate},
+	{0xBB, "LAS", 3, 4, 1, amAbsoluteY},
+
+	// 不稳定的指令，结果和具体的芯片有关，按常见的行为实现
+	{0x8B, "XAA", 2, 2, 0, amImmediate},
+	{0xAB, "LXA", 2, 2, 0, amImmediate},
+	{0x93, "AHX", 2, 6, 0, amIndirectIndexed},
+	{0x9F, "AHX", 3, 5, 0, amAbsoluteY},
+	{0x9C, "SHY", 3, 5, 0, amAbsoluteX},
+	{0x9E, "SHX", 3, 5, 0, amAbsoluteY},
+	{0x9B, "TAS", 3, 5, 0, amAbsoluteY},
+
+	// 让 CPU 停机，只有复位才能恢复
+	{0x02, "KIL", 1, 2, 0, amImplied},
+	{0x12, "KIL", 1, 2, 0, amImplied},
+	{0x22, "KIL", 1, 2, 0, amImplied},
+	{0x32, "KIL", 1, 2, 0, amImplied},
+	{0x42, "KIL", 1, 2, 0, amImplied},
+	{0x52, "KIL", 1, 2, 0, amImplied},
+	{0x62, "KIL", 1, 2, 0, amImplied},
+	{0x72, "KIL", 1, 2, 0, amImplied},
+	{0x92, "KIL", 1, 2, 0, amImplied},
+	{0xB2, "KIL", 1, 2, 0, amImplied},
+	{0xD2, "KIL", 1, 2, 0, amImplied},
+	{0xF2, "KIL", 1, 2, 0, amImplied},
+}
+
+var (
+	opcodeModes     = [256]byte{}
+	opcodeSizes     = [256]byte{}
+	opcodePagedSize = [256]byte{}
+	opcodeCycles    = [256]byte{}
+	opcodeNames     = [256]string{}
+	opcodeAccess    = [256]byte{}
+	opcodeOfficial  = [256]bool{}
+)
+
+func init() {
+
+	opcTable256 := [256]OpCode{}
+	for _, code := range opcodesTable {
+		opcTable256[code.code] = code
+		opcodeOfficial[code.code] = true
+	}
+	for _, code := range unofficialOpcodesTable {
+		opcTable256[code.code] = code
+	}
+
+	for i, opc := range opcTable256 {
+		if opc.name == "" {
+			panic(fmt.Sprintf("missing opcode: %02X", i))
+		}
+
+		opcodeModes[i] = opc.mode
+		opcodeSizes[i] = opc.size
+		opcodePagedSize[i] = opc.paged
+		opcodeCycles[i] = opc.cycles
+		opcodeNames[i] = opc.name
+
+		switch opc.name {
+		case "STA", "STX", "STY", "SAX", "AHX", "SHX", "SHY", "TAS":
+			opcodeAccess[i] = accessWrite
+		case "ASL", "LSR", "ROL", "ROR", "INC", "DEC",
+			"DCP", "ISB", "SLO", "RLA", "SRE", "RRA":
+			opcodeAccess[i] = accessRMW
+		}
+	}
+}
+
+type stepContext struct {
+	a    uint16 // 有效地址
+	mode byte
+}
+
+type opCodeFunc func(*stepContext)
+
+// 状态标志位（Status Bits）
+type Flag struct {
+	C byte // 进位（Carry）
+	Z byte // 零（Zero）
+	I byte // 中断控制
+	D byte // 十进制模式
+	B byte // 中断
+	U byte // 未使用
+	V byte // 溢出
+	N byte // 负数
+}
+
+func (o *Flag) SetFlags(bits byte) {
+	o.C = bits >> 0 & 1
+	o.Z = bits >> 1 & 1
+	o.I = bits >> 2 & 1
+	o.D = bits >> 3 & 1
+	o.B = bits >> 4 & 1
+	o.U = bits >> 5 & 1
+	o.V = bits >> 6 & 1
+	o.N = bits >> 7 & 1
+}
+
+func (o *Flag) GetFlags() byte {
+	var f byte
+	f |= o.C << 0
+	f |= o.Z << 1
+	f |= o.I << 2
+	f |= o.D << 3
+	f |= o.B << 4
+	f |= o.U << 5
+	f |= o.V << 6
+	f |= o.N << 7
+	return f
+}
+
+func (o *Flag) SetZ(V byte) {
+	if V == 0 {
+		o.Z = 1
+	} else {
+		o.Z = 0
+	}
+}
+
+func (o *Flag) SetN(V byte) {
+	if V&0x80 != 0 {
+		o.N = 1
+	} else {
+		o.N = 0
+	}
+}
+
+func (o *Flag) SetZN(V byte) {
+	o.SetN(V)
+	o.SetZ(V)
+}
+
+type CPU struct {
+	A                byte            // 累加寄存器（Accumulator）
+	X                byte            // 索引寄存器（Index Register）
+	Y                byte            // 索引寄存器（Index Register）
+	SP               byte            // 栈指针（Stack Pointer）
+	PC               uint16          // 指令指针
+	Flag                             // 状态寄存器（Status Register）
+	Cycles           uint64          // 指令周期
+	opcodes          [256]opCodeFunc // 指令执行函数
+	irq              byte            // 当前中断
+	RAM              [2048]byte      // CPU RAM
+	MemoryReadWriter                 // 内存读写实现
+	suspendCycles    uint32          // 暂时执行的周期数（比如DMA发生时）
+	tracer           io.Writer       // 指令跟踪输出
+	console          *Console        // 总线访问时驱动 PPU 和 APU
+	halted           bool            // 执行了 KIL，只有复位能恢复
+	haltPC           uint16          // KIL 指令的地址
+	haltOpcode       byte            // KIL 指令的操作码
+}
+
+func NewCPU(console *Console) *CPU {
+	cpu := &CPU{console: console}
+	cpu.createOpcodeFuncs()
+	cpu.MemoryReadWriter = NewCPUMemory(console)
+	return cpu
+}
+
+// Reset 和中断的过程一样占用 7 个周期，只是压栈变成了读，SP 减 3
+// 上电时 SP 为 0，复位后就是 $FD
+func (o *CPU) Reset() {
+	o.halted = false
+	o.irq = intNone
+	o.suspendCycles = 0
+	o.read(o.PC)
+	o.read(o.PC)
+	for i := 0; i < 3; i++ {
+		o.read(0x100 | uint16(o.SP))
+		o.SP--
+	}
+	o.SetFlags(0x24)
+	o.PC = o.read16(0xFFFC)
+}
+
+// Halted 返回停机的原因，正常运行时返回 nil
+func (o *CPU) Halted() error {
+	if !o.halted {
+		return nil
+	}
+	return &HaltError{PC: o.haltPC, Opcode: o.haltOpcode}
+}
+
+func (o *CPU) Save(enc *gob.Encoder) error {
+	return encodeAll(enc,
+		o.A, o.X, o.Y, o.SP, o.PC, o.GetFlags(),
+		o.Cycles, o.irq, o.RAM, o.suspendCycles,
+		o.halted, o.haltPC, o.haltOpcode,
+	)
+}
+
+func (o *CPU) Load(dec *gob.Decoder) error {
+	var flags byte
+	err := decodeAll(dec,
+		&o.A, &o.X, &o.Y, &o.SP, &o.PC, &flags,
+		&o.Cycles, &o.irq, &o.RAM, &o.suspendCycles,
+		&o.halted, &o.haltPC, &o.haltOpcode,
+	)
+	if err != nil {
+		return err
+	}
+	o.SetFlags(flags)
+	return nil
+}
+
+// PrintInstruction 按 nestest.log（Nintendulator）的格式输出即将执行的指令和当前状态
+func (o *CPU) PrintInstruction(w io.Writer) {
+	pc := o.PC
+	opcode := o.debugRead(pc)
+	size := opcodeSizes[opcode]
+	name := opcodeNames[opcode]
+	mode := opcodeModes[opcode]
+
+	b1 := o.debugRead(pc + 1)
+	b2 := o.debugRead(pc + 2)
+	abs := uint16(b2)<<8 | uint16(b1)
+
+	codes := fmt.Sprintf("%02X", opcode)
+	if size > 1 {
+		codes += fmt.Sprintf(" %02X", b1)
+	}
+	if size > 2 {
+		codes += fmt.Sprintf(" %02X", b2)
+	}
+
+	oprands := ""
+
+	switch mode {
+	case amAbsolute:
+		if name == "JMP" || name == "JSR" {
+			oprands = fmt.Sprintf("$%04X", abs)
+		} else {
+			oprands = fmt.Sprintf("$%04X = %02X", abs, o.debugRead(abs))
+		}
+	case amAbsoluteX:
+		a := abs + uint16(o.X)
+		oprands = fmt.Sprintf("$%04X,X @ %04X = %02X", abs, a, o.debugRead(a))
+	case amAbsoluteY:
+		a := abs + uint16(o.Y)
+		oprands = fmt.Sprintf("$%04X,Y @ %04X = %02X", abs, a, o.debugRead(a))
+	case amAccumulator:
+		oprands = "A"
+	case amImmediate:
+		oprands = fmt.Sprintf("#$%02X", b1)
+	case amImplied:
+		break
+	case amIndexedIndirect:
+		p := b1 + o.X
+		a := o.debugRead16Bug(uint16(p))
+		oprands = fmt.Sprintf("($%02X,X) @ %02X = %04X = %02X", b1, p, a, o.debugRead(a))
+	case amIndirect:
+		oprands = fmt.Sprintf("($%04X) = %04X", abs, o.debugRead16Bug(abs))
+	case amIndirectIndexed:
+		base := o.debugRead16Bug(uint16(b1))
+		a := base + uint16(o.Y)
+		oprands = fmt.Sprintf("($%02X),Y = %04X @ %04X = %02X", b1, base, a, o.debugRead(a))
+	case amRelative:
+		oprands = fmt.Sprintf("$%04X", pc+2+uint16(int8(b1)))
+	case amZero:
+		oprands = fmt.Sprintf("$%02X = %02X", b1, o.debugRead(uint16(b1)))
+	case amZeroX:
+		a := b1 + o.X
+		oprands = fmt.Sprintf("$%02X,X @ %02X = %02X", b1, a, o.debugRead(uint16(a)))
+	case amZeroY:
+		a := b1 + o.Y
+		oprands = fmt.Sprintf("$%02X,Y @ %02X = %02X", b1, a, o.debugRead(uint16(a)))
+	}
+
+	mark := " "
+	if !opcodeOfficial[opcode] {
+		mark = "*"
+	}
+
+	ppu := o.console.ppu
+
+	fmt.Fprintf(w,
+		"%04X  %-8s %s%-32sA:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d\n",
+		pc, codes, mark, strings.TrimSpace(name+" "+oprands),
+		o.A, o.X, o.Y, o.GetFlags(), o.SP,
+		ppu.Scanline, ppu.Cycle, o.Cycles)
+}
+
+// 跟踪用的读取，不占用周期
+// I/O 寄存器读取有副作用（比如清除标志位），不去读它们，按开路总线显示 $FF
+func (o *CPU) debugRead(A uint16) byte {
+	if A >= 0x2000 && A < 0x6000 {
+		return 0xFF
+	}
+	return o.Read(A)
+}
+
+func (o *CPU) debugRead16Bug(A uint16) uint16 {
+	lo := o.debugRead(A)
+	hi := o.debugRead(A&0xFF00 | uint16(byte(A)+1))
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// Step 执行一条指令或者响应一个中断，返回消耗的周期数
+// 每次总线访问都通过 read/write 进行，各占一个周期
+func (o *CPU) Step() int {
+	cycles := o.Cycles
+
+	// 停机后不再取指，也不响应中断，但时钟还在走
+	if o.halted {
+		o.tick()
+		return 1
+	}
+
+	// DMC 抓取采样时 CPU 暂停
+	if o.suspendCycles > 0 {
+		o.suspendCycles--
+		o.tick()
+		return 1
+	}
+
+	switch o.irq {
+	case intNMI:
+		o.irq = intNone
+		o.interrupt(0xFFFA)
+		return int(o.Cycles - cycles)
+	case intIRQ:
+		o.irq = intNone
+		if o.I == 0 {
+			o.interrupt(0xFFFE)
+			return int(o.Cycles - cycles)
+		}
+	}
+
+	if o.tracer != nil {
+		o.PrintInstruction(o.tracer)
+	}
+
+	opcode := o.fetch()
+	mode := opcodeModes[opcode]
+
+	var A uint16
+	// JSR 的地址高字节在压栈之后才读，由 jsr 自己处理
+	if opcode != 0x20 {
+		A = o.address(mode, opcodeAccess[opcode])
+	}
+
+	ctx := &stepContext{A, mode}
+	o.opcodes[opcode](ctx)
+
+	return int(o.Cycles - cycles)
+}
+
+// 按寻址模式计算有效地址，包括其间所有的读取和哑读
+// 操作数本身的读写由指令完成
+func (o *CPU) address(mode byte, access byte) uint16 {
+	switch mode {
+	case amImmediate:
+		A := o.PC
+		o.PC++
+		return A
+	case amImplied, amAccumulator:
+		// 读一次下一个字节，但不前进
+		o.read(o.PC)
+		return 0
+	case amZero:
+		return uint16(o.fetch())
+	case amZeroX:
+		base := o.fetch()
+		o.read(uint16(base))
+		return uint16(base + o.X)
+	case amZeroY:
+		base := o.fetch()
+		o.read(uint16(base))
+		return uint16(base + o.Y)
+	case amAbsolute:
+		return o.fetch16()
+	case amAbsoluteX:
+		return o.indexed(o.fetch16(), o.X, access)
+	case amAbsoluteY:
+		return o.indexed(o.fetch16(), o.Y, access)
+	case amIndirect:
+		return o.read16Bug(o.fetch16())
+	case amIndexedIndirect:
+		ptr := o.fetch()
+		o.read(uint16(ptr))
+		return o.read16Bug(uint16(ptr + o.X))
+	case amIndirectIndexed:
+		return o.indexed(o.read16Bug(uint16(o.fetch())), o.Y, access)
+	case amRelative:
+		offset := o.fetch()
+		return o.PC + uint16(int8(offset))
+	}
+	return 0
+}
+
+// 变址时 CPU 先用没有进位的地址读一次，跨页时这次是哑读；
+// 写和读-改-写指令不管跨不跨页都有这次哑读
+func (o *CPU) indexed(base uint16, index byte, access byte) uint16 {
+	A := base + uint16(index)
+	if pagesDiffer(base, A) || access != accessRead {
+		o.read(base&0xFF00 | A&0x00FF)
+	}
+	return A
+}
+
+func pagesDiffer(a, b uint16) bool {
+	return a&0xFF00 != b&0xFF00
+}
+
+// 一个 CPU 周期，PPU 和 APU 同步前进
+func (o *CPU) tick() {
+	o.Cycles++
+	o.console.clock()
+}
+
+// 占用一个周期的总线读
+func (o *CPU) read(A uint16) byte {
+	o.tick()
+	return o.Read(A)
+}
+
+// 占用一个周期的总线写
+func (o *CPU) write(A uint16, v byte) {
+	o.tick()
+	o.Write(A, v)
+}
+
+// 读取 PC 处的字节并前进
+func (o *CPU) fetch() byte {
+	v := o.read(o.PC)
+	o.PC++
+	return v
+}
+
+func (o *CPU) fetch16() uint16 {
+	lo := uint16(o.fetch())
+	hi := uint16(o.fetch())
+	return hi<<8 | lo
+}
+
+// 跳转成功多一个周期，跨页再多一个
+func (o *CPU) branch(ctx *stepContext, taken bool) {
+	if !taken {
+		return
+	}
+	o.read(o.PC)
+	if pagesDiffer(o.PC, ctx.a) {
+		o.read(o.PC&0xFF00 | ctx.a&0x00FF)
+	}
+	o.PC = ctx.a
+}
+
+func (o *CPU) compare(a, b byte) {
+	o.SetZN(a - b)
+	if a >= b {
+		o.C = 1
+	} else {
+		o.C = 0
+	}
+}
+
+// Read16 直接读取两个字节，不占用周期
+func (o *CPU) Read16(A uint16) uint16 {
+	lo := uint16(o.Read(A))
+	hi := uint16(o.Read(A + 1))
+	return hi<<8 | lo
+}
+
+func (o *CPU) read16(A uint16) uint16 {
+	lo := uint16(o.read(A))
+	hi := uint16(o.read(A + 1))
+	return hi<<8 | lo
+}
+
+// 高字节不跨页：($10FF) 读取的是 $10FF 和 $1000
+func (o *CPU) read16Bug(A uint16) uint16 {
+	a := A
+	b := (a & 0xFF00) | uint16(byte(a)+1)
+	lo := o.read(a)
+	hi := o.read(b)
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+func (o *CPU) push(v byte) {
+	o.write(0x100|uint16(o.SP), v)
+	o.SP--
+}
+
+func (o *CPU) pop() byte {
+	o.SP++
+	return o.read(0x100 | uint16(o.SP))
+}
+
+// 出栈之前先哑读一次栈顶
+func (o *CPU) peek() {
+	o.read(0x100 | uint16(o.SP))
+}
+
+func (o *CPU) push16(v uint16) {
+	o.push(byte(v >> 8))
+	o.push(byte(v & 0xFF))
+}
+
+func (o *CPU) pop16() uint16 {
+	lo := uint16(o.pop())
+	hi := uint16(o.pop())
+	return hi<<8 | lo
+}
+
+func (o *CPU) triggerNMI() {
+	o.irq = intNMI
+}
+
+// 不覆盖已经挂起的 NMI
+func (o *CPU) triggerIRQ() {
+	if o.I == 0 && o.irq == intNone {
+		o.irq = intIRQ
+	}
+}
+
+// 响应中断：两次哑读，压入 PC 和 P（B 位为 0），读取中断向量，共 7 个周期
+func (o *CPU) interrupt(vector uint16) {
+	o.read(o.PC)
+	o.read(o.PC)
+	o.push16(o.PC)
+	o.push(o.GetFlags()&^0x10 | 0x20)
+	o.I = 1
+	o.PC = o.read16(vector)
+}
+
+func (o *CPU) createOpcodeFuncs() {
+	o.opcodes = [256]opCodeFunc{
+		o.brk, o.ora, o.kil, o.slo, o.nop, o.ora, o.asl, o.slo,
+		o.php, o.ora, o.asl, o.anc, o.nop, o.ora, o.asl, o.slo,
+		o.bpl, o.ora, o.kil, o.slo, o.nop, o.ora, o.asl, o.slo,
+		o.clc, o.ora, o.nop, o.slo, o.nop, o.ora, o.asl, o.slo,
+		o.jsr, o.and, o.kil, o.rla, o.bit, o.and, o.rol, o.rla,
+		o.plp, o.and, o.rol, o.anc, o.bit, o.and, o.rol, o.rla,
+		o.bmi, o.and, o.kil, o.rla, o.nop, o.and, o.rol, o.rla,
+		o.sec, o.and, o.nop, o.rla, o.nop, o.and, o.rol, o.rla,
+		o.rti, o.eor, o.kil, o.sre, o.nop, o.eor, o.lsr, o.sre,
+		o.pha, o.eor, o.lsr, o.alr, o.jmp, o.eor, o.lsr, o.sre,
+		o.bvc, o.eor, o.kil, o.sre, o.nop, o.eor, o.lsr, o.sre,
+		o.cli, o.eor, o.nop, o.sre, o.nop, o.eor, o.lsr, o.sre,
+		o.rts, o.adc, o.kil, o.rra, o.nop, o.adc, o.ror, o.rra,
+		o.pla, o.adc, o.ror, o.arr, o.jmp, o.adc, o.ror, o.rra,
+		o.bvs, o.adc, o.kil, o.rra, o.nop, o.adc, o.ror, o.rra,
+		o.sei, o.adc, o.nop, o.rra, o.nop, o.adc, o.ror, o.rra,
+		o.nop, o.sta, o.nop, o.sax, o.sty, o.sta, o.stx, o.sax,
+		o.dey, o.nop, o.txa, o.xaa, o.sty, o.sta, o.stx, o.sax,
+		o.bcc, o.sta, o.kil, o.ahx, o.sty, o.sta, o.stx, o.sax,
+		o.tya, o.sta, o.txs, o.tas, o.shy, o.sta, o.shx, o.ahx,
+		o.ldy, o.lda, o.ldx, o.lax, o.ldy, o.lda, o.ldx, o.lax,
+		o.tay, o.lda, o.tax, o.lxa, o.ldy, o.lda, o.ldx, o.lax,
+		o.bcs, o.lda, o.kil, o.lax, o.ldy, o.lda, o.ldx, o.lax,
+		o.clv, o.lda, o.tsx, o.las, o.ldy, o.lda, o.ldx, o.lax,
+		o.cpy, o.cmp, o.nop, o.dcp, o.cpy, o.cmp, o.dec, o.dcp,
+		o.iny, o.cmp, o.dex, o.axs, o.cpy, o.cmp, o.dec, o.dcp,
+		o.bne, o.cmp, o.kil, o.dcp, o.nop, o.cmp, o.dec, o.dcp,
+		o.cld, o.cmp, o.nop, o.dcp, o.nop, o.cmp, o.dec, o.dcp,
+		o.cpx, o.sbc, o.nop, o.isb, o.cpx, o.sbc, o.inc, o.isb,
+		o.inx, o.sbc, o.nop, o.sbc, o.cpx, o.sbc, o.inc, o.isb,
+		o.beq, o.sbc, o.kil, o.isb, o.nop, o.sbc, o.inc, o.isb,
+		o.sed, o.sbc, o.nop, o.isb, o.nop, o.sbc, o.inc, o.isb,
+	}
+}
+
+// A,Z,C,N = A+M+C
+func (o *CPU) adc(ctx *stepContext) {
+	o.add(o.read(ctx.a))
+}
+
+func (o *CPU) add(b byte) {
+	a := o.A
+	c := o.C
+	o.A = a + b + c
+	o.SetZN(o.A)
+	if int(a)+int(b)+int(c) > 0xFF {
+		o.C = 1
+	} else {
+		o.C = 0
+	}
+	if (a^b)&0x80 == 0 && (a^o.A)&0x80 != 0 {
+		o.V = 1
+	} else {
+		o.V = 0
+	}
+}
+
+// A,Z,N = A & M
+func (o *CPU) and(ctx *stepContext) {
+	o.A &= o.read(ctx.a)
+	o.SetZN(o.A)
+}
+
+// A,Z,C,N = M*2 or M,Z,C,N = M*2
+func (o *CPU) asl(ctx *stepContext) {
+	if ctx.mode == amAccumulator {
+		o.C = o.A >> 7 & 1
+		o.A <<= 1
+		o.SetZN(o.A)
+	} else {
+		V := o.read(ctx.a)
+		o.write(ctx.a, V)
+		o.C = V >> 7 & 1
+		V <<= 1
+		o.write(ctx.a, V)
+		o.SetZN(V)
+	}
+}
+
+// branch on C == 0
+func (o *CPU) bcc(ctx *stepContext) {
+	o.branch(ctx, o.C == 0)
+}
+
+// branch on C == 1
+func (o *CPU) bcs(ctx *stepContext) {
+	o.branch(ctx, o.C != 0)
+}
+
+func (o *CPU) beq(ctx *stepContext) {
+	o.branch(ctx, o.Z != 0)
+}
+
+// A & M, N = M7, V = M6
+func (o *CPU) bit(ctx *stepContext) {
+	V := o.read(ctx.a)
+	o.V = V >> 6 & 1
+	o.SetZ(V & o.A)
+	o.SetN(V)
+}
+
+func (o *CPU) bmi(ctx *stepContext) {
+	o.branch(ctx, o.N != 0)
+}
+
+func (o *CPU) bne(ctx *stepContext) {
+	o.branch(ctx, o.Z == 0)
+}
+
+func (o *CPU) bpl(ctx *stepContext) {
+	o.branch(ctx, o.N == 0)
+}
+
+// BRK 后面有一个填充字节，压栈的是 BRK+2
+func (o *CPU) brk(ctx *stepContext) {
+	o.PC++
+	o.push16(o.PC)
+	o.php(ctx)
+	o.sei(ctx)
+	o.PC = o.read16(0xFFFE)
+}
+
+func (o *CPU) bvc(ctx *stepContext) {
+	o.branch(ctx, o.V == 0)
+}
+
+func (o *CPU) bvs(ctx *stepContext) {
+	o.branch(ctx, o.V != 0)
+}
+
+func (o *CPU) clc(ctx *stepContext) {
+	o.C = 0
+}
+
+func (o *CPU) cld(ctx *stepContext) {
+	o.D = 0
+}
+
+func (o *CPU) cli(ctx *stepContext) {
+	o.I = 0
+}
+
+func (o *CPU) clv(ctx *stepContext) {
+	o.V = 0
+}
+
+func (o *CPU) cmp(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.compare(o.A, v)
+}
+
+func (o *CPU) cpx(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.compare(o.X, v)
+}
+
+func (o *CPU) cpy(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.compare(o.Y, v)
+}
+
+func (o *CPU) dec(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	v--
+	o.write(ctx.a, v)
+	o.SetZN(v)
+}
+
+func (o *CPU) dex(c *stepContext) {
+	o.X--
+	o.SetZN(o.X)
+}
+
+func (o *CPU) dey(c *stepContext) {
+	o.Y--
+	o.SetZN(o.Y)
+}
+
+func (o *CPU) eor(ctx *stepContext) {
+	o.A ^= o.read(ctx.a)
+	o.SetZN(o.A)
+}
+
+func (o *CPU) inc(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	v++
+	o.write(ctx.a, v)
+	o.SetZN(v)
+}
+
+func (o *CPU) inx(c *stepContext) {
+	o.X++
+	o.SetZN(o.X)
+}
+
+func (o *CPU) iny(c *stepContext) {
+	o.Y++
+	o.SetZN(o.Y)
+}
+
+func (o *CPU) jmp(ctx *stepContext) {
+	o.PC = ctx.a
+}
+
+// 读低字节，哑读栈，压入 PC（指向高字节），最后读高字节
+func (o *CPU) jsr(ctx *stepContext) {
+	lo := uint16(o.fetch())
+	o.peek()
+	o.push16(o.PC)
+	hi := uint16(o.read(o.PC))
+	o.PC = hi<<8 | lo
+}
+
+func (o *CPU) lda(ctx *stepContext) {
+	o.A = o.read(ctx.a)
+	o.SetZN(o.A)
+}
+
+func (o *CPU) ldx(ctx *stepContext) {
+	o.X = o.read(ctx.a)
+	o.SetZN(o.X)
+}
+
+func (o *CPU) ldy(ctx *stepContext) {
+	o.Y = o.read(ctx.a)
+	o.SetZN(o.Y)
+}
+
+func (o *CPU) lsr(ctx *stepContext) {
+	if ctx.mode == amAccumulator {
+		o.C = o.A & 1
+		o.A >>= 1
+		o.SetZN(o.A)
+	} else {
+		v := o.read(ctx.a)
+		o.write(ctx.a, v)
+		o.C = v & 1
+		v >>= 1
+		o.write(ctx.a, v)
+		o.SetZN(v)
+	}
+}
+
+// 非官方的 NOP 也会读取操作数
+func (o *CPU) nop(ctx *stepContext) {
+	if ctx.mode != amImplied {
+		o.read(ctx.a)
+	}
+}
+
+func (o *CPU) ora(ctx *stepContext) {
+	o.A |= o.read(ctx.a)
+	o.SetZN(o.A)
+}
+
+func (o *CPU) pha(c *stepContext) {
+	o.push(o.A)
+}
+
+func (o *CPU) php(c *stepContext) {
+	o.push(o.GetFlags() | 0x10)
+}
+
+func (o *CPU) pla(c *stepContext) {
+	o.peek()
+	o.A = o.pop()
+	o.SetZN(o.A)
+}
+
+func (o *CPU) plp(c *stepContext) {
+	o.peek()
+	o.SetFlags(o.pop()&0xEF | 0x20)
+}
+
+func (o *CPU) rol(ctx *stepContext) {
+	if ctx.mode == amAccumulator {
+		c := o.C
+		o.C = (o.A >> 7) & 1
+		o.A = (o.A << 1) | c
+		o.SetZN(o.A)
+	} else {
+		c := o.C
+		v := o.read(ctx.a)
+		o.write(ctx.a, v)
+		o.C = (v >> 7) & 1
+		v = (v << 1) | c
+		o.write(ctx.a, v)
+		o.SetZN(v)
+	}
+}
+
+func (o *CPU) ror(ctx *stepContext) {
+	if ctx.mode == amAccumulator {
+		c := o.C
+		o.C = o.A & 1
+		o.A = o.A>>1 | c<<7
+		o.SetZN(o.A)
+	} else {
+		c := o.C
+		v := o.read(ctx.a)
+		o.write(ctx.a, v)
+		o.C = v & 1
+		v = v>>1 | c<<7
+		o.write(ctx.a, v)
+		o.SetZN(v)
+	}
+}
+
+func (o *CPU) rti(ctx *stepContext) {
+	o.peek()
+	o.SetFlags(o.pop()&0xEF | 0x20)
+	o.PC = o.pop16()
+}
+
+func (o *CPU) rts(ctx *stepContext) {
+	o.peek()
+	o.PC = o.pop16()
+	o.read(o.PC)
+	o.PC++
+}
+
+func (o *CPU) sbc(ctx *stepContext) {
+	o.sub(o.read(ctx.a))
+}
+
+func (o *CPU) sub(b byte) {
+	a := o.A
+	c := o.C
+	o.A = a - b - (1 - c)
+	o.SetZN(o.A)
+	if int(a)-int(b)-int(1-c) >= 0 {
+		o.C = 1
+	} else {
+		o.C = 0
+	}
+	if (a^b)&0x80 != 0 && (a^o.A)&0x80 != 0 {
+		o.V = 1
+	} else {
+		o.V = 0
+	}
+}
+
+func (o *CPU) sec(ctx *stepContext) {
+	o.C = 1
+}
+
+func (o *CPU) sed(ctx *stepContext) {
+	o.D = 1
+}
+
+func (o *CPU) sei(ctx *stepContext) {
+	o.I = 1
+}
+
+func (o *CPU) sta(ctx *stepContext) {
+	o.write(ctx.a, o.A)
+}
+
+func (o *CPU) stx(ctx *stepContext) {
+	o.write(ctx.a, o.X)
+}
+
+func (o *CPU) sty(ctx *stepContext) {
+	o.write(ctx.a, o.Y)
+}
+
+func (o *CPU) tax(c *stepContext) {
+	o.X = o.A
+	o.SetZN(o.X)
+}
+
+func (o *CPU) tay(c *stepContext) {
+	o.Y = o.A
+	o.SetZN(o.Y)
+}
+
+func (o *CPU) tsx(c *stepContext) {
+	o.X = o.SP
+	o.SetZN(o.X)
+}
+
+func (o *CPU) txa(c *stepContext) {
+	o.A = o.X
+	o.SetZN(o.A)
+}
+
+func (o *CPU) txs(c *stepContext) {
+	o.SP = o.X
+}
+
+func (o *CPU) tya(c *stepContext) {
+	o.A = o.Y
+	o.SetZN(o.A)
+}
+
+// 以下是非官方指令
+
+func (o *CPU) kil(ctx *stepContext) {
+	o.PC--
+	o.halted = true
+	o.haltPC = o.PC
+	o.haltOpcode = o.Read(o.PC)
+}
+
+// A,X,Z,N = M
+func (o *CPU) lax(ctx *stepContext) {
+	o.A = o.read(ctx.a)
+	o.X = o.A
+	o.SetZN(o.A)
+}
+
+// M = A & X
+func (o *CPU) sax(ctx *stepContext) {
+	o.write(ctx.a, o.A&o.X)
+}
+
+// M = M-1, A-M
+func (o *CPU) dcp(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	v--
+	o.write(ctx.a, v)
+	o.compare(o.A, v)
+}
+
+// M = M+1, A = A-M-(1-C)
+func (o *CPU) isb(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	v++
+	o.write(ctx.a, v)
+	o.sub(v)
+}
+
+// M = M*2, A = A | M
+func (o *CPU) slo(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	o.C = v >> 7 & 1
+	v <<= 1
+	o.write(ctx.a, v)
+	o.A |= v
+	o.SetZN(o.A)
+}
+
+// M = M<<1 | C, A = A & M
+func (o *CPU) rla(ctx *stepContext) {
+	c := o.C
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	o.C = v >> 7 & 1
+	v = v<<1 | c
+	o.write(ctx.a, v)
+	o.A &= v
+	o.SetZN(o.A)
+}
+
+// M = M>>1, A = A ^ M
+func (o *CPU) sre(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	o.C = v & 1
+	v >>= 1
+	o.write(ctx.a, v)
+	o.A ^= v
+	o.SetZN(o.A)
+}
+
+// M = M>>1 | C<<7, A = A+M+C
+func (o *CPU) rra(ctx *stepContext) {
+	c := o.C
+	v := o.read(ctx.a)
+	o.write(ctx.a, v)
+	o.C = v & 1
+	v = v>>1 | c<<7
+	o.write(ctx.a, v)
+	o.add(v)
+}
+
+// A = A & M, C = N
+func (o *CPU) anc(ctx *stepContext) {
+	o.A &= o.read(ctx.a)
+	o.SetZN(o.A)
+	o.C = o.N
+}
+
+// A = (A & M) >> 1
+func (o *CPU) alr(ctx *stepContext) {
+	o.A &= o.read(ctx.a)
+	o.C = o.A & 1
+	o.A >>= 1
+	o.SetZN(o.A)
+}
+
+// A = (A & M) >> 1 | C<<7, C = A6, V = A6 ^ A5
+func (o *CPU) arr(ctx *stepContext) {
+	o.A &= o.read(ctx.a)
+	o.A = o.A>>1 | o.C<<7
+	o.SetZN(o.A)
+	o.C = o.A >> 6 & 1
+	o.V = (o.A>>6 ^ o.A>>5) & 1
+}
+
+// X = (A & X) - M，不借位
+func (o *CPU) axs(ctx *stepContext) {
+	v := o.read(ctx.a)
+	o.compare(o.A&o.X, v)
+	o.X = o.A&o.X - v
+}
+
+// A,X,SP = M & SP
+func (o *CPU) las(ctx *stepContext) {
+	v := o.read(ctx.a) & o.SP
+	o.A = v
+	o.X = v
+	o.SP = v
+	o.SetZN(v)
+}
+
+// A = (A | magic) & X & M
+func (o *CPU) xaa(ctx *stepContext) {
+	o.A = (o.A | 0xEE) & o.X & o.read(ctx.a)
+	o.SetZN(o.A)
+}
+
+// A,X = (A | magic) & M
+func (o *CPU) lxa(ctx *stepContext) {
+	o.A = (o.A | 0xEE) & o.read(ctx.a)
+	o.X = o.A
+	o.SetZN(o.A)
+}
+
+// M = A & X & (H+1)
+func (o *CPU) ahx(ctx *stepContext) {
+	o.storeHigh(ctx, o.Y, o.A&o.X)
+}
+
+// M = Y & (H+1)
+func (o *CPU) shy(ctx *stepContext) {
+	o.storeHigh(ctx, o.X, o.Y)
+}
+
+// M = X & (H+1)
+func (o *CPU) shx(ctx *stepContext) {
+	o.storeHigh(ctx, o.Y, o.X)
+}
+
+// SP = A & X, M = SP & (H+1)
+func (o *CPU) tas(ctx *stepContext) {
+	o.SP = o.A & o.X
+	o.storeHigh(ctx, o.Y, o.SP)
+}
+
+// 写入 v & (H+1)，H 是变址之前基址的高字节
+// 跨页时地址的高字节也会被替换成写入的值
+func (o *CPU) storeHigh(ctx *stepContext, index byte, v byte) {
+	base := ctx.a - uint16(index)
+	v &= byte(base>>8) + 1
+	A := ctx.a
+	if pagesDiffer(base, A) {
+		A = uint16(v)<<8 | A&0x00FF
+	}
+	o.write(A, v)
+}
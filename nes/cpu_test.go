@@ -0,0 +1,142 @@
+package nes
+
+import "testing"
+
+// 把 program 放在 $8000 运行，程序最后应该停在 JMP * 上
+func runProgram(t *testing.T, program []byte) *Console {
+	t.Helper()
+	prg := make([]byte, 32768)
+	copy(prg, program)
+	prg[0x7FFC], prg[0x7FFD] = 0x00, 0x80
+
+	console := NewConsole()
+	if err := console.LoadCartridge(NewCartridge(prg, make([]byte, 8192), 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		console.StepFrame()
+	}
+	if err := console.Halted(); err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+// 变址写和读-改-写指令的哑读会读到只写的 PPU 寄存器，读到的是总线上残留的值
+func TestDummyReadWriteOnlyRegisters(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+	}{
+		{"sta abs,x", []byte{
+			0xA2, 0x00, // LDX #0
+			0x9D, 0x00, 0x20, // STA $2000,X
+			0x9D, 0x05, 0x20, // STA $2005,X
+		}},
+		{"sta (zp),y", []byte{
+			0xA9, 0x06, 0x85, 0x10, // LDA #$06  STA $10
+			0xA9, 0x20, 0x85, 0x11, // LDA #$20  STA $11
+			0xA0, 0x00, // LDY #0
+			0x91, 0x10, // STA ($10),Y
+		}},
+		{"rmw", []byte{
+			0xEE, 0x01, 0x20, // INC $2001
+			0xA2, 0x02, // LDX #2
+			0x1E, 0x01, 0x20, // ASL $2001,X
+			0x0E, 0x14, 0x40, // ASL $4014
+		}},
+		{"lda", []byte{
+			0xAD, 0x00, 0x20, // LDA $2000
+			0xAD, 0x06, 0x20, // LDA $2006
+			0xAD, 0x14, 0x40, // LDA $4014
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// JMP *
+			loop := uint16(0x8000 + len(tt.program))
+			program := append(tt.program, 0x4C, byte(loop), byte(loop>>8))
+
+			console := runProgram(t, program)
+			if pc := console.cpu.PC; pc != loop {
+				t.Fatalf("pc = $%04X, want $%04X", pc, loop)
+			}
+		})
+	}
+}
+
+func TestReadWriteOnlyRegisterOpenBus(t *testing.T) {
+	console := runProgram(t, []byte{0x4C, 0x00, 0x80})
+	memory := console.cpu.MemoryReadWriter
+
+	memory.Write(0x2003, 0x5A)
+	for _, a := range []uint16{0x2000, 0x2001, 0x2003, 0x2005, 0x2006, 0x3FF8, 0x4014} {
+		if v := memory.Read(a); v != 0x5A {
+			t.Errorf("$%04X = $%02X, want $5A", a, v)
+		}
+	}
+}
+
+// 逐条执行每个操作码，实际消耗的周期数必须和指令表里的一致，包括跨页多出的周期
+func TestOpcodeCycles(t *testing.T) {
+	console := runProgram(t, []byte{0x4C, 0x00, 0x80})
+	cpu := console.cpu
+
+	// 在 $0200 执行一条指令，操作数指向 RAM，crossed 时变址会跨页
+	step := func(opcode byte, crossed bool) (cycles int, taken bool) {
+		base, index := uint16(0x0300), byte(0)
+		if crossed {
+			base, index = 0x03F0, 0x20
+		}
+		program := []byte{opcode, byte(base), byte(base >> 8)}
+		switch opcodeModes[opcode] {
+		case amIndexedIndirect:
+			program[1] = 0x10
+			cpu.Write(uint16(0x10+index), byte(base))
+			cpu.Write(uint16(0x11+index), byte(base>>8))
+		case amIndirectIndexed:
+			program[1] = 0x10
+			cpu.Write(0x10, byte(base))
+			cpu.Write(0x11, byte(base>>8))
+		case amRelative:
+			program[1] = 0x10
+		}
+		for i, v := range program {
+			cpu.Write(0x0200+uint16(i), v)
+		}
+
+		cpu.PC, cpu.SP = 0x0200, 0xFD
+		cpu.X, cpu.Y = index, index
+		cpu.SetFlags(0x24)
+		cpu.irq = intNone
+		cycles = cpu.Step()
+		return cycles, cpu.PC == 0x0212
+	}
+
+	for i := 0; i < 256; i++ {
+		opcode := byte(i)
+		if opcodeNames[opcode] == "KIL" {
+			continue
+		}
+		for _, crossed := range []bool{false, true} {
+			mode := opcodeModes[opcode]
+			if crossed && mode != amAbsoluteX && mode != amAbsoluteY && mode != amIndirectIndexed {
+				continue
+			}
+			want := int(opcodeCycles[opcode])
+			if crossed {
+				want += int(opcodePagedSize[opcode])
+			}
+			cycles, taken := step(opcode, crossed)
+			// 跳转成功多一个周期，目标 $0212 不跨页
+			if mode == amRelative && taken {
+				want++
+			}
+			if cycles != want {
+				t.Errorf("$%02X %s crossed=%v: %d cycles, want %d",
+					opcode, opcodeNames[opcode], crossed, cycles, want)
+			}
+		}
+	}
+}
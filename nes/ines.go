@@ -0,0 +1,250 @@
+// https://wiki.nesdev.com/w/index.php/INES
+// https://wiki.nesdev.com/w/index.php/NES_2.0
+
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const iNESMagic = 0x1a53454e
+
+var (
+	ErrBadMagic  = errors.New("nes: not an iNES file")
+	ErrBadHeader = errors.New("nes: bad iNES header")
+	ErrTruncated = errors.New("nes: rom file truncated")
+)
+
+// PRG/CHR-ROM 大小的上限，NES 2.0 普通表示法最大约 60M
+const maxROMSize = 64 << 20
+
+type iNESHeader struct {
+	Magic    uint32
+	NumPRG   byte // PRG-ROM 大小低8位，16K 为单位
+	NumCHR   byte // CHR-ROM 大小低8位，8K 为单位
+	Control1 byte // 第6字节
+	Control2 byte // 第7字节
+	Mapper   byte // 第8字节：NES 2.0 时为 mapper 高4位和子 mapper
+	ROMSize  byte // 第9字节：NES 2.0 时为 PRG/CHR-ROM 大小高4位
+	PRGRAM   byte // 第10字节：NES 2.0 时为 PRG-(NV)RAM 大小
+	CHRRAM   byte // 第11字节：NES 2.0 时为 CHR-(NV)RAM 大小
+	Timing   byte // 第12字节
+	System   byte // 第13字节：Vs. System 类型或扩展主机类型
+	MiscROMs byte // 第14字节
+	Device   byte // 第15字节：默认扩展设备
+}
+
+// 第7字节的第2、3位为 10 时是 NES 2.0 格式
+func (o *iNESHeader) isNES20() bool {
+	return o.Control2&0x0C == 0x08
+}
+
+// 老的工具会在第7-15字节写入垃圾数据（比如 "DiskDude!"），
+// 这种情况下第7字节不可信
+func (o *iNESHeader) isDirty() bool {
+	return o.Timing != 0 || o.System != 0 || o.MiscROMs != 0 || o.Device != 0
+}
+
+// NES 2.0 的 ROM 大小
+// 高4位为 $F 时使用指数表示法：2^E * (MM*2+1)，E 最大可以到 63
+func romSize(lo byte, hi byte, unit int) (int, error) {
+	size := (int(hi)<<8 | int(lo)) * unit
+	if hi == 0x0F {
+		e := uint(lo >> 2)
+		m := int(lo&3)*2 + 1
+		if uint64(1)<<e > maxROMSize {
+			return 0, fmt.Errorf("%w: rom size 2^%d*%d too large", ErrBadHeader, e, m)
+		}
+		size = (1 << e) * m
+	}
+	if size > maxROMSize {
+		return 0, fmt.Errorf("%w: rom size %d too large", ErrBadHeader, size)
+	}
+	return size, nil
+}
+
+// NES 2.0 的 RAM 大小：0 表示没有，否则为 64 << shift
+func ramSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+// LoadROM 从文件加载 ROM
+func LoadROM(path string) (*Cartridge, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fp.Close()
+
+	return ReadROM(fp)
+}
+
+// LoadROMBytes 从内存加载 ROM
+func LoadROMBytes(data []byte) (*Cartridge, error) {
+	return ReadROM(bytes.NewReader(data))
+}
+
+// 读满 buf，读不够时返回 ErrTruncated
+func readFull(r io.Reader, buf []byte, what string) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: reading %s", ErrTruncated, what)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReadROM 从 r 读取 iNES/NES 2.0 格式的 ROM
+func ReadROM(r io.Reader) (*Cartridge, error) {
+	var buf [16]byte
+	if err := readFull(r, buf[:], "header"); err != nil {
+		return nil, err
+	}
+
+	header := iNESHeader{}
+	binary.Read(bytes.NewReader(buf[:]), binary.LittleEndian, &header)
+
+	if header.Magic != iNESMagic {
+		return nil, ErrBadMagic
+	}
+
+	nes20 := header.isNES20()
+
+	// 第7-15字节是垃圾数据，全部当作 0
+	if !nes20 && header.isDirty() {
+		header.Control2 = 0
+		header.Mapper = 0
+		header.ROMSize = 0
+		header.PRGRAM = 0
+		header.CHRRAM = 0
+		header.Timing = 0
+		header.System = 0
+		header.MiscROMs = 0
+		header.Device = 0
+	}
+
+	mapper1 := header.Control1 >> 4
+	mapper2 := header.Control2 >> 4
+	mapper := uint16(mapper1 | mapper2<<4)
+
+	mirror := header.Control1 & 1
+	if header.Control1&8 == 8 {
+		mirror = mirrorFour
+	}
+
+	var trainer []byte
+	if header.Control1&4 == 4 {
+		trainer = make([]byte, trainerSize)
+		if err := readFull(r, trainer, "trainer"); err != nil {
+			return nil, err
+		}
+	}
+
+	prgSize := int(header.NumPRG) * 16384
+	chrSize := int(header.NumCHR) * 8192
+	if nes20 {
+		var err error
+		if prgSize, err = romSize(header.NumPRG, header.ROMSize&0x0F, 16384); err != nil {
+			return nil, err
+		}
+		if chrSize, err = romSize(header.NumCHR, header.ROMSize>>4, 8192); err != nil {
+			return nil, err
+		}
+	}
+
+	// mapper 按 bank 切换 PRG，不足一个 bank 的部分没法访问
+	if bank := prgBankSize(mapper); prgSize == 0 || prgSize%bank != 0 {
+		return nil, fmt.Errorf("%w: prg size %d is not a multiple of %d", ErrBadHeader, prgSize, bank)
+	}
+	// CHR-ROM 至少要填满 $0000-$1FFF
+	if chrSize%8192 != 0 {
+		return nil, fmt.Errorf("%w: chr size %d is not a multiple of 8192", ErrBadHeader, chrSize)
+	}
+
+	prg := make([]byte, prgSize)
+	if err := readFull(r, prg, "prg"); err != nil {
+		return nil, err
+	}
+
+	chr := make([]byte, chrSize)
+	if err := readFull(r, chr, "chr"); err != nil {
+		return nil, err
+	}
+
+	cart := NewCartridge(prg, chr, mapper, mirror)
+	cart.Battery = header.Control1&2 == 2
+	cart.Trainer = trainer
+	cart.ConsoleType = header.Control2 & 3
+	cart.NES20 = nes20
+
+	if nes20 {
+		cart.Mapper |= uint16(header.Mapper&0x0F) << 8
+		cart.Submapper = header.Mapper >> 4
+		cart.PRGRAMSize = ramSize(header.PRGRAM & 0x0F)
+		cart.PRGNVRAMSize = ramSize(header.PRGRAM >> 4)
+		cart.CHRRAMSize = ramSize(header.CHRRAM & 0x0F)
+		cart.CHRNVRAMSize = ramSize(header.CHRRAM >> 4)
+		cart.Timing = header.Timing & 3
+		switch cart.ConsoleType {
+		case ConsoleVs:
+			cart.VsPPUType = header.System & 0x0F
+			cart.VsHardwareType = header.System >> 4
+		case ConsoleExtended:
+			cart.ExtendedConsole = header.System & 0x0F
+		}
+		cart.MiscROMs = header.MiscROMs & 3
+		cart.ExpansionDevice = header.Device & 0x3F
+	} else {
+		// iNES 1.0 只能推断：PRG-RAM 按 8K 算，没有 CHR-ROM 时按 8K CHR-RAM 算
+		if cart.Battery {
+			cart.PRGNVRAMSize = defaultSRAMSize
+		} else {
+			cart.PRGRAMSize = defaultSRAMSize
+		}
+		if chrSize == 0 {
+			cart.CHRRAMSize = defaultCHRRAMSize
+		}
+		if header.ROMSize&1 == 1 {
+			cart.Timing = TimingPAL
+		}
+	}
+
+	cart.configure()
+
+	return cart, nil
+}
+
+// 根据元数据分配 RAM
+func (o *Cartridge) configure() {
+	if o.PRGNVRAMSize > 0 {
+		o.Battery = true
+	}
+
+	// mapper 按 $6000-$7FFF 整个窗口访问，至少分配 8K
+	if n := o.PRGRAMSize + o.PRGNVRAMSize; n > len(o.SRAM) {
+		o.SRAM = make([]byte, n)
+	}
+
+	if len(o.CHR) == 0 {
+		o.chrRAM = true
+		n := o.CHRRAMSize + o.CHRNVRAMSize
+		if n < defaultCHRRAMSize {
+			n = defaultCHRRAMSize
+		}
+		o.CHR = make([]byte, n)
+	}
+
+	if o.Trainer != nil {
+		copy(o.SRAM[trainerOffset:], o.Trainer)
+	}
+}
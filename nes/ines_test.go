@@ -0,0 +1,150 @@
+package nes
+
+import (
+	"errors"
+	"testing"
+)
+
+// 按文件头拼出一个 ROM 文件，PRG 和 CHR 的大小由调用者给出
+func inesFile(header string, prgSize, chrSize int) []byte {
+	data := make([]byte, 16+prgSize+chrSize)
+	copy(data, "NES\x1a")
+	copy(data[4:16], header)
+	return data
+}
+
+func TestReadROMHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string // 第4-15字节
+		prg     int
+		chr     int
+		want    Cartridge
+		wantErr error
+	}{
+		{
+			name:   "ines",
+			header: "\x02\x01\x13\x00\x00\x01",
+			prg:    32768, chr: 8192,
+			want: Cartridge{Mapper: 1, Mirror: 1, Battery: true, PRGNVRAMSize: 8192, Timing: TimingPAL},
+		},
+		{
+			name:   "nes20",
+			header: "\x04\x02\x40\x08\x10\x00\x07\x07\x01",
+			prg:    65536, chr: 16384,
+			want: Cartridge{
+				Mapper: 4, Submapper: 1, NES20: true,
+				PRGRAMSize: 8192, CHRRAMSize: 8192, Timing: TimingPAL,
+			},
+		},
+		{
+			name:   "nes20 exponent",
+			header: "\x3C\x00\x00\x08\x00\x0F",
+			prg:    32768,
+			want:   Cartridge{NES20: true},
+		},
+		{
+			name:    "nes20 exponent too large",
+			header:  "\xFF\x00\x00\x08\x00\x0F",
+			prg:     32768,
+			wantErr: ErrBadHeader,
+		},
+		{
+			name:    "nes20 exponent over limit",
+			header:  "\x69\x00\x00\x08\x00\x0F",
+			prg:     32768,
+			wantErr: ErrBadHeader,
+		},
+		{
+			// 第7-15字节是垃圾，mapper 高4位和第9字节的 PAL 标志都不能用
+			name:   "diskdude",
+			header: "\x02\x01\x10DiskDude!",
+			prg:    32768, chr: 8192,
+			want: Cartridge{Mapper: 1, PRGRAMSize: 8192},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cart, err := LoadROMBytes(inesFile(tt.header, tt.prg, tt.chr))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(cart.PRG) != tt.prg {
+				t.Errorf("prg = %d, want %d", len(cart.PRG), tt.prg)
+			}
+			if cart.chrRAM == (tt.chr > 0) {
+				t.Errorf("chr ram = %v, want %v", cart.chrRAM, tt.chr == 0)
+			}
+
+			got, want := cart, &tt.want
+			if got.Mapper != want.Mapper || got.Submapper != want.Submapper || got.Mirror != want.Mirror {
+				t.Errorf("mapper = %d.%d mirror %d, want %d.%d mirror %d",
+					got.Mapper, got.Submapper, got.Mirror, want.Mapper, want.Submapper, want.Mirror)
+			}
+			if got.NES20 != want.NES20 || got.Battery != want.Battery || got.Timing != want.Timing {
+				t.Errorf("nes20 %v battery %v timing %d, want %v %v %d",
+					got.NES20, got.Battery, got.Timing, want.NES20, want.Battery, want.Timing)
+			}
+			if got.PRGRAMSize != want.PRGRAMSize || got.PRGNVRAMSize != want.PRGNVRAMSize {
+				t.Errorf("prg ram %d/%d, want %d/%d",
+					got.PRGRAMSize, got.PRGNVRAMSize, want.PRGRAMSize, want.PRGNVRAMSize)
+			}
+			if tt.chr > 0 && got.CHRRAMSize != want.CHRRAMSize {
+				t.Errorf("chr ram %d, want %d", got.CHRRAMSize, want.CHRRAMSize)
+			}
+		})
+	}
+}
+
+func TestReadROMErrors(t *testing.T) {
+	nrom := inesFile("\x02\x01", 32768, 8192)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"short header", nrom[:10], ErrTruncated},
+		{"bad magic", append([]byte("NES\x00"), nrom[4:]...), ErrBadMagic},
+		{"trainer", inesFile("\x02\x01\x04", 100, 0), ErrTruncated},
+		{"prg", nrom[:16+16384], ErrTruncated},
+		{"chr", nrom[:len(nrom)-1], ErrTruncated},
+		{"no prg", inesFile("\x00\x01", 0, 8192), ErrBadHeader},
+		{"partial prg bank", inesFile("\x34\x00\x00\x08\x00\x0F", 8192, 0), ErrBadHeader},
+		{"partial chr bank", inesFile("\x02\x30\x00\x08\x00\xF0", 32768, 4096), ErrBadHeader},
+		{"ok", nrom, nil},
+		// MMC3 按 8K 切换，8K 的 PRG 是可以的
+		{"mmc3 8k prg", inesFile("\x34\x00\x40\x08\x00\x0F", 8192, 0), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadROMBytes(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnsupportedMapper(t *testing.T) {
+	cart, err := LoadROMBytes(inesFile("\x02\x01\xF0\xF0", 32768, 8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewConsole().LoadCartridge(cart)
+	var mapperErr *UnsupportedMapperError
+	if !errors.Is(err, ErrUnsupportedMapper) || !errors.As(err, &mapperErr) || mapperErr.Mapper != 255 {
+		t.Fatalf("err = %v", err)
+	}
+}
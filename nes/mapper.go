@@ -0,0 +1,181 @@
+// https://wiki.nesdev.com/w/index.php/Mapper
+
+package nes
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+type Mapper interface {
+	Read(a uint16) byte
+	Write(a uint16, v byte)
+	Step()        // 每个 PPU 周期调用一次
+	Mirror() byte // 当前的命名表镜像方式，mapper 可以在运行时切换
+
+	// 即时存档：保存和恢复 bank 寄存器等内部状态
+	// PRG-RAM 和 CHR-RAM 由卡带负责
+	Save(enc *gob.Encoder) error
+	Load(dec *gob.Decoder) error
+}
+
+// 需要观察 PPU 地址总线的 mapper 实现此接口
+// 比如 MMC3 靠 A12 的上升沿来计数扫描线
+type PPUAddressWatcher interface {
+	WatchPPUAddress(a uint16)
+}
+
+var ErrUnsupportedMapper = errors.New("nes: unsupported mapper")
+
+// UnsupportedMapperError 带上了不支持的 mapper 号
+// errors.Is(err, ErrUnsupportedMapper) 成立
+type UnsupportedMapperError struct {
+	Mapper uint16
+}
+
+func (e *UnsupportedMapperError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrUnsupportedMapper, e.Mapper)
+}
+
+func (e *UnsupportedMapperError) Unwrap() error {
+	return ErrUnsupportedMapper
+}
+
+// mapper 切换 PRG 的最小单位，PRG-ROM 的大小必须是它的整数倍
+func prgBankSize(mapper uint16) int {
+	switch mapper {
+	case 0, 1, 2:
+		return 0x4000
+	}
+	return 0x2000
+}
+
+func NewMapper(console *Console, cart *Cartridge) (Mapper, error) {
+	switch cart.Mapper {
+	case 0:
+		return NewMapper0(console, cart), nil
+	case 1:
+		return NewMapper1(console, cart), nil
+	case 2:
+		return NewMapper2(console, cart), nil
+	case 4:
+		return NewMapper4(console, cart), nil
+	}
+	return nil, &UnsupportedMapperError{Mapper: cart.Mapper}
+}
+
+// NROM (Mapper 0)
+type xMapper0 struct {
+	console *Console
+	*Cartridge
+}
+
+func NewMapper0(console *Console, cart *Cartridge) Mapper {
+	return &xMapper0{
+		console,
+		cart,
+	}
+}
+
+func (o *xMapper0) Read(a uint16) byte {
+	switch {
+	case a < 0x2000:
+		return o.CHR[a]
+	case a >= 0x8000:
+		// 16K 的 PRG（NROM-128）在 $C000 处镜像
+		return o.PRG[int(a-0x8000)%len(o.PRG)]
+	case a >= 0x6000:
+		return o.SRAM[a-0x6000]
+	}
+	return 0
+}
+
+func (o *xMapper0) Write(a uint16, v byte) {
+	if a >= 0x6000 && a < 0x8000 {
+		o.SRAM[a-0x6000] = v
+	}
+}
+
+func (o *xMapper0) Step() {
+
+}
+
+func (o *xMapper0) Mirror() byte {
+	return o.Cartridge.Mirror
+}
+
+func (o *xMapper0) Save(enc *gob.Encoder) error {
+	return nil
+}
+
+func (o *xMapper0) Load(dec *gob.Decoder) error {
+	return nil
+}
+
+// UxROM (Mapper 2)
+type xMapper2 struct {
+	console *Console
+	cart    *Cartridge
+	banks   [][]byte // $8000-$BFFF
+	last    []byte   // $C000-$FFFF
+	nprg    int
+	bank    byte
+}
+
+func NewMapper2(console *Console, cart *Cartridge) Mapper {
+	m := &xMapper2{}
+	m.console = console
+	m.cart = cart
+
+	m.nprg = len(cart.PRG) / 16384
+	m.last = cart.PRG[(m.nprg-1)*16384:]
+	m.banks = make([][]byte, m.nprg)
+	for i := 0; i < m.nprg; i++ {
+		m.banks[i] = cart.PRG[i*16384 : (i+1)*16384]
+	}
+
+	return m
+}
+
+func (o *xMapper2) Read(a uint16) byte {
+	switch {
+	case a < 0x2000:
+		return o.cart.CHR[a]
+	case a >= 0x8000 && a <= 0xBFFF:
+		return o.banks[o.bank][a-0x8000]
+	case a >= 0xC000:
+		return o.last[a-0xC000]
+	case a >= 0x6000:
+		return o.cart.SRAM[a-0x6000]
+	}
+	// $4020-$5FFF 没有映射
+	return 0
+}
+
+func (o *xMapper2) Write(a uint16, v byte) {
+	switch {
+	case a < 0x2000:
+		o.cart.CHR[a] = v
+	case a >= 0x8000 && a <= 0xFFFF:
+		o.bank = byte(int(v) % o.nprg)
+	case a >= 0x6000:
+		o.cart.SRAM[a-0x6000] = v
+	}
+}
+
+func (o *xMapper2) Step() {
+
+}
+
+func (o *xMapper2) Mirror() byte {
+	return o.cart.Mirror
+}
+
+func (o *xMapper2) Save(enc *gob.Encoder) error {
+	return encodeAll(enc, o.bank)
+}
+
+func (o *xMapper2) Load(dec *gob.Decoder) error {
+	return decodeAll(dec, &o.bank)
+}
@@ -0,0 +1,198 @@
+// https://wiki.nesdev.com/w/index.php/MMC1
+
+package nes
+
+import (
+	"encoding/gob"
+)
+
+// MMC1 (Mapper 1)
+type xMapper1 struct {
+	console *Console
+	cart    *Cartridge
+
+	shift   byte // 5位串行移位寄存器，最高位的1用来判断是否写满
+	control byte // $8000-$9FFF
+	chr0    byte // $A000-$BFFF
+	chr1    byte // $C000-$DFFF
+	prg     byte // $E000-$FFFF，第4位为1时禁用 PRG-RAM
+	mirror  byte
+
+	lastWrite uint64 // 上一次串行写入时的 CPU 周期
+
+	prgOffsets [2]int // 两个 16K 的 PRG 窗口
+	chrOffsets [2]int // 两个 4K 的 CHR 窗口
+}
+
+func NewMapper1(console *Console, cart *Cartridge) Mapper {
+	m := &xMapper1{}
+	m.console = console
+	m.cart = cart
+	m.mirror = cart.Mirror
+	m.shift = 0x10
+	m.control = 0x0C // 上电时固定最后一个 bank 到 $C000
+	m.updateOffsets()
+	return m
+}
+
+func (o *xMapper1) Read(a uint16) byte {
+	switch {
+	case a < 0x2000:
+		bank := a / 0x1000
+		offset := int(a % 0x1000)
+		return o.cart.CHR[o.chrOffsets[bank]+offset]
+	case a >= 0x8000:
+		a -= 0x8000
+		bank := a / 0x4000
+		offset := int(a % 0x4000)
+		return o.cart.PRG[o.prgOffsets[bank]+offset]
+	case a >= 0x6000:
+		if o.prg&0x10 == 0 {
+			return o.cart.SRAM[a-0x6000]
+		}
+	}
+	return 0
+}
+
+func (o *xMapper1) Write(a uint16, v byte) {
+	switch {
+	case a < 0x2000:
+		bank := a / 0x1000
+		offset := int(a % 0x1000)
+		o.cart.CHR[o.chrOffsets[bank]+offset] = v
+	case a >= 0x8000:
+		o.loadRegister(a, v)
+	case a >= 0x6000:
+		if o.prg&0x10 == 0 {
+			o.cart.SRAM[a-0x6000] = v
+		}
+	}
+}
+
+func (o *xMapper1) Step() {
+
+}
+
+func (o *xMapper1) Mirror() byte {
+	return o.mirror
+}
+
+func (o *xMapper1) Save(enc *gob.Encoder) error {
+	return encodeAll(enc, o.shift, o.control, o.chr0, o.chr1, o.prg, o.mirror, o.lastWrite)
+}
+
+func (o *xMapper1) Load(dec *gob.Decoder) error {
+	err := decodeAll(dec, &o.shift, &o.control, &o.chr0, &o.chr1, &o.prg, &o.mirror, &o.lastWrite)
+	if err != nil {
+		return err
+	}
+	o.updateOffsets()
+	return nil
+}
+
+// 串行写入：每次一位，写满5位后送到地址对应的寄存器
+// 连续两个周期的写入（读-改-写指令的两次写）只有第一次有效
+func (o *xMapper1) loadRegister(a uint16, v byte) {
+	cycle := o.console.cpu.Cycles
+	consecutive := cycle == o.lastWrite+1
+	o.lastWrite = cycle
+	if consecutive {
+		return
+	}
+
+	// 第7位置1：复位移位寄存器
+	if v&0x80 == 0x80 {
+		o.shift = 0x10
+		o.writeControl(o.control | 0x0C)
+		return
+	}
+
+	complete := o.shift&1 == 1
+	o.shift >>= 1
+	o.shift |= (v & 1) << 4
+
+	if complete {
+		o.writeRegister(a, o.shift)
+		o.shift = 0x10
+	}
+}
+
+func (o *xMapper1) writeRegister(a uint16, v byte) {
+	switch {
+	case a <= 0x9FFF:
+		o.writeControl(v)
+	case a <= 0xBFFF:
+		o.chr0 = v
+	case a <= 0xDFFF:
+		o.chr1 = v
+	default:
+		o.prg = v
+	}
+	o.updateOffsets()
+}
+
+// 控制寄存器
+// CPPMM
+// |||++- 命名表镜像 0: 单屏低，1: 单屏高，2: 垂直，3: 水平
+// |++--- PRG 模式 0,1: 32K；2: 固定第一个 bank 到 $8000；3: 固定最后一个 bank 到 $C000
+// +----- CHR 模式 0: 8K，1: 两个 4K
+func (o *xMapper1) writeControl(v byte) {
+	o.control = v
+
+	switch v & 3 {
+	case 0:
+		o.mirror = mirrorSingle0
+	case 1:
+		o.mirror = mirrorSingle1
+	case 2:
+		o.mirror = mirrorVertical
+	case 3:
+		o.mirror = mirrorHorizontal
+	}
+
+	o.updateOffsets()
+}
+
+func (o *xMapper1) prgBankOffset(index int) int {
+	n := len(o.cart.PRG) / 0x4000
+	index %= n
+	return index * 0x4000
+}
+
+func (o *xMapper1) chrBankOffset(index int) int {
+	n := len(o.cart.CHR) / 0x1000
+	index %= n
+	return index * 0x1000
+}
+
+func (o *xMapper1) updateOffsets() {
+	prg := int(o.prg & 0x0F)
+
+	// SUROM：512K 的 PRG 由 CHR 寄存器的第4位选择高低 256K
+	outer := 0
+	if len(o.cart.PRG) > 0x40000 {
+		outer = int(o.chr0 & 0x10)
+	}
+
+	switch o.control >> 2 & 3 {
+	case 0, 1:
+		bank := prg & 0x0E
+		o.prgOffsets[0] = o.prgBankOffset(outer | bank)
+		o.prgOffsets[1] = o.prgBankOffset(outer | bank + 1)
+	case 2:
+		o.prgOffsets[0] = o.prgBankOffset(outer)
+		o.prgOffsets[1] = o.prgBankOffset(outer | prg)
+	case 3:
+		o.prgOffsets[0] = o.prgBankOffset(outer | prg)
+		o.prgOffsets[1] = o.prgBankOffset(outer | 0x0F)
+	}
+
+	if o.control>>4&1 == 0 {
+		bank := int(o.chr0 & 0x1E)
+		o.chrOffsets[0] = o.chrBankOffset(bank)
+		o.chrOffsets[1] = o.chrBankOffset(bank + 1)
+	} else {
+		o.chrOffsets[0] = o.chrBankOffset(int(o.chr0))
+		o.chrOffsets[1] = o.chrBankOffset(int(o.chr1))
+	}
+}
@@ -0,0 +1,256 @@
+// https://wiki.nesdev.com/w/index.php/MMC3
+
+package nes
+
+import (
+	"encoding/gob"
+)
+
+// PPU 地址线 A12 需要保持低电平这么多个 PPU 周期，
+// 之后的上升沿才会被 MMC3 当作一次扫描线计数（过滤背景抓取时的抖动）
+const mmc3A12Filter = 10
+
+// MMC3 (Mapper 4)
+type xMapper4 struct {
+	console *Console
+	cart    *Cartridge
+
+	register  byte    // 下一次写 $8001 的目标寄存器
+	registers [8]byte // R0-R7
+	prgMode   byte
+	chrMode   byte
+	mirror    byte
+
+	prgRAMEnabled bool
+	prgRAMWrite   bool
+
+	prgOffsets [4]int // 4 个 8K 的 PRG 窗口
+	chrOffsets [8]int // 8 个 1K 的 CHR 窗口
+
+	irqLatch   byte
+	irqCounter byte
+	irqReload  bool
+	irqEnable  bool
+	irqPending bool
+
+	a12    bool // A12 当前电平
+	a12Low int  // A12 持续低电平的 PPU 周期数
+}
+
+func NewMapper4(console *Console, cart *Cartridge) Mapper {
+	m := &xMapper4{}
+	m.console = console
+	m.cart = cart
+	m.mirror = cart.Mirror
+	m.prgRAMEnabled = true
+	m.prgRAMWrite = true
+	m.updateOffsets()
+	return m
+}
+
+func (o *xMapper4) Read(a uint16) byte {
+	switch {
+	case a < 0x2000:
+		bank := a / 0x0400
+		offset := int(a % 0x0400)
+		return o.cart.CHR[o.chrOffsets[bank]+offset]
+	case a >= 0x8000:
+		a -= 0x8000
+		bank := a / 0x2000
+		offset := int(a % 0x2000)
+		return o.cart.PRG[o.prgOffsets[bank]+offset]
+	case a >= 0x6000:
+		if o.prgRAMEnabled {
+			return o.cart.SRAM[a-0x6000]
+		}
+	}
+	return 0
+}
+
+func (o *xMapper4) Write(a uint16, v byte) {
+	switch {
+	case a < 0x2000:
+		bank := a / 0x0400
+		offset := int(a % 0x0400)
+		o.cart.CHR[o.chrOffsets[bank]+offset] = v
+	case a >= 0x8000:
+		o.writeRegister(a, v)
+	case a >= 0x6000:
+		if o.prgRAMEnabled && o.prgRAMWrite {
+			o.cart.SRAM[a-0x6000] = v
+		}
+	}
+}
+
+// 每个 PPU 周期调用一次
+func (o *xMapper4) Step() {
+	if !o.a12 {
+		o.a12Low++
+	}
+
+	// IRQ 是电平触发的，确认之前一直有效
+	if o.irqPending {
+		o.console.cpu.triggerIRQ()
+	}
+}
+
+func (o *xMapper4) Mirror() byte {
+	return o.mirror
+}
+
+func (o *xMapper4) Save(enc *gob.Encoder) error {
+	return encodeAll(enc,
+		o.register, o.registers, o.prgMode, o.chrMode, o.mirror,
+		o.prgRAMEnabled, o.prgRAMWrite,
+		o.irqLatch, o.irqCounter, o.irqReload, o.irqEnable, o.irqPending,
+		o.a12, o.a12Low,
+	)
+}
+
+func (o *xMapper4) Load(dec *gob.Decoder) error {
+	err := decodeAll(dec,
+		&o.register, &o.registers, &o.prgMode, &o.chrMode, &o.mirror,
+		&o.prgRAMEnabled, &o.prgRAMWrite,
+		&o.irqLatch, &o.irqCounter, &o.irqReload, &o.irqEnable, &o.irqPending,
+		&o.a12, &o.a12Low,
+	)
+	if err != nil {
+		return err
+	}
+	o.updateOffsets()
+	return nil
+}
+
+// WatchPPUAddress 观察 PPU 地址总线，在 A12 的上升沿计数
+func (o *xMapper4) WatchPPUAddress(a uint16) {
+	a12 := a&0x1000 != 0
+	if a12 && !o.a12 {
+		if o.a12Low >= mmc3A12Filter {
+			o.clockCounter()
+		}
+	}
+	if a12 {
+		o.a12Low = 0
+	}
+	o.a12 = a12
+}
+
+func (o *xMapper4) clockCounter() {
+	if o.irqCounter == 0 || o.irqReload {
+		o.irqCounter = o.irqLatch
+		o.irqReload = false
+	} else {
+		o.irqCounter--
+	}
+	if o.irqCounter == 0 && o.irqEnable {
+		o.irqPending = true
+	}
+}
+
+func (o *xMapper4) writeRegister(a uint16, v byte) {
+	even := a&1 == 0
+	switch {
+	case a <= 0x9FFF && even:
+		o.writeBankSelect(v)
+	case a <= 0x9FFF:
+		o.writeBankData(v)
+	case a <= 0xBFFF && even:
+		o.writeMirror(v)
+	case a <= 0xBFFF:
+		o.writeProtect(v)
+	case a <= 0xDFFF && even:
+		o.irqLatch = v
+	case a <= 0xDFFF:
+		o.irqCounter = 0
+		o.irqReload = true
+	case even:
+		o.irqEnable = false
+		o.irqPending = false
+	default:
+		o.irqEnable = true
+	}
+}
+
+// $8000
+// CPMx xRRR
+func (o *xMapper4) writeBankSelect(v byte) {
+	o.prgMode = v >> 6 & 1
+	o.chrMode = v >> 7 & 1
+	o.register = v & 7
+	o.updateOffsets()
+}
+
+// $8001
+func (o *xMapper4) writeBankData(v byte) {
+	o.registers[o.register] = v
+	o.updateOffsets()
+}
+
+// $A000
+func (o *xMapper4) writeMirror(v byte) {
+	// 卡带自带了额外的显存，不受控制
+	if o.mirror == mirrorFour {
+		return
+	}
+	switch v & 1 {
+	case 0:
+		o.mirror = mirrorVertical
+	case 1:
+		o.mirror = mirrorHorizontal
+	}
+}
+
+// $A001
+// RWxx xxxx
+func (o *xMapper4) writeProtect(v byte) {
+	o.prgRAMEnabled = v&0x80 != 0
+	o.prgRAMWrite = v&0x40 == 0
+}
+
+func (o *xMapper4) prgBankOffset(index int) int {
+	n := len(o.cart.PRG) / 0x2000
+	index %= n
+	if index < 0 {
+		index += n
+	}
+	return index * 0x2000
+}
+
+func (o *xMapper4) chrBankOffset(index int) int {
+	n := len(o.cart.CHR) / 0x0400
+	index %= n
+	return index * 0x0400
+}
+
+func (o *xMapper4) updateOffsets() {
+	r := &o.registers
+
+	switch o.prgMode {
+	case 0:
+		o.prgOffsets[0] = o.prgBankOffset(int(r[6]))
+		o.prgOffsets[1] = o.prgBankOffset(int(r[7]))
+		o.prgOffsets[2] = o.prgBankOffset(-2)
+		o.prgOffsets[3] = o.prgBankOffset(-1)
+	case 1:
+		o.prgOffsets[0] = o.prgBankOffset(-2)
+		o.prgOffsets[1] = o.prgBankOffset(int(r[7]))
+		o.prgOffsets[2] = o.prgBankOffset(int(r[6]))
+		o.prgOffsets[3] = o.prgBankOffset(-1)
+	}
+
+	// R0、R1 是 2K 的 bank，R2-R5 是 1K 的 bank
+	// chrMode 为 1 时两半互换
+	var banks = [8]int{
+		int(r[0] & 0xFE), int(r[0] | 0x01),
+		int(r[1] & 0xFE), int(r[1] | 0x01),
+		int(r[2]), int(r[3]), int(r[4]), int(r[5]),
+	}
+
+	for i := 0; i < 8; i++ {
+		j := i
+		if o.chrMode == 1 {
+			j ^= 4
+		}
+		o.chrOffsets[j] = o.chrBankOffset(banks[i])
+	}
+}
@@ -1,8 +1,4 @@
-package main
-
-import (
-	"log"
-)
+package nes
 
 type MemoryReadWriter interface {
 	Read(a uint16) byte
@@ -25,15 +21,16 @@ func (o *CPUMemory) Read(a uint16) byte {
 		return o.console.ppu.readRegister(0x2000 + a&7)
 	case a == 0x4014:
 		return o.console.ppu.readRegister(a)
+	case a == 0x4015:
+		return o.console.apu.readRegister(a)
 	case a == 0x4016:
-		return o.console.ctrl1.Read()
+		return readController(o.console.ctrl1)
 	case a == 0x4017:
-		break
+		return readController(o.console.ctrl2)
 	case a >= 0x6000:
 		return o.console.mapper.Read(a)
-	default:
-		log.Fatalf("unhandled cpu memory read at 0x%04X\n", a)
 	}
+	// 只写的寄存器和没有映射的地址，变址寻址的哑读可能会读到这里
 	return 0
 }
 
@@ -44,20 +41,29 @@ func (o *CPUMemory) Write(a uint16, v byte) {
 	case a < 0x4000:
 		o.console.ppu.writeRegister(0x2000+a&7, v)
 	case a < 0x4014:
-		break
+		o.console.apu.writeRegister(a, v)
 	case a == 0x4014:
 		o.console.ppu.writeRegister(a, v)
 	case a == 0x4016:
-		o.console.ctrl1.Flush(o.console.ppu.FrameCount)
+		// 两个手柄共用选通信号
+		o.console.ctrl1.Strobe(v&1 != 0, o.console.ppu.FrameCount)
+		o.console.ctrl2.Strobe(v&1 != 0, o.console.ppu.FrameCount)
 	case a < 0x4018:
-		break
+		o.console.apu.writeRegister(a, v)
 	case a >= 0x6000:
 		o.console.mapper.Write(a, v)
 	default:
-		log.Fatalf("unhandled cpu memory write at 0x%04X\n", a)
+		// $4018-$5FFF 没有映射，忽略
 	}
 }
 
+// 读手柄：先取数据，读信号结束时再移位
+func readController(ctrl ControllerProvider) byte {
+	v := ctrl.Read(controllerOpenBus)
+	ctrl.Shift()
+	return v
+}
+
 type PPUMemory struct {
 	console *Console
 }
@@ -68,35 +74,36 @@ func NewPPUMemory(console *Console) MemoryReadWriter {
 
 func (o *PPUMemory) Read(a uint16) byte {
 	a &= 0x3FFF
+	if w := o.console.watcher; w != nil && a < 0x3F00 {
+		w.WatchPPUAddress(a)
+	}
 	switch {
 	// 图案表
 	case a < 0x2000:
 		return o.console.mapper.Read(a)
 	// 命名表 & 属性表
 	case a < 0x3F00:
-		mirror := o.console.cart.Mirror
-		return o.console.ppu.nameTable[mirrorAddress(mirror, a)&0x07FF]
-	// 调色板
-	case a < 0x4000:
-		return o.console.ppu.readPalette(a & 0x1F)
+		mirror := o.console.mapper.Mirror()
+		return o.console.ppu.nameTable[mirrorAddress(mirror, a)&0x0FFF]
+	// 调色板，地址已经屏蔽到 14 位，剩下的都在这里
 	default:
-		log.Fatalf("unhandled ppu memory read at: 0x%04X\n", a)
+		return o.console.ppu.readPalette(a & 0x1F)
 	}
-	return 0
 }
 
 func (o *PPUMemory) Write(a uint16, v byte) {
 	a = a & 0x3FFF
+	if w := o.console.watcher; w != nil && a < 0x3F00 {
+		w.WatchPPUAddress(a)
+	}
 	switch {
 	case a < 0x2000:
 		o.console.mapper.Write(a, v)
 	case a < 0x3F00:
-		mode := o.console.cart.Mirror
-		o.console.ppu.nameTable[mirrorAddress(mode, a)&0x07FF] = v
-	case a < 0x4000:
-		o.console.ppu.writePalette(a&0x1F, v)
+		mode := o.console.mapper.Mirror()
+		o.console.ppu.nameTable[mirrorAddress(mode, a)&0x0FFF] = v
 	default:
-		log.Fatalf("unhandled ppu memory write at address: 0x%04X", a)
+		o.console.ppu.writePalette(a&0x1F, v)
 	}
 }
 
@@ -0,0 +1,530 @@
+package nes
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ 输入录像，使用 FCEUX 的 .fm2 文本格式：
+
+ version 3
+ romFilename game
+ romChecksum base64:<PRG 和 CHR 的 MD5>
+ guid 01234567-89AB-CDEF-0123-456789ABCDEF
+ palFlag 0
+ port0 1
+ port1 1
+ |0|RLDUTSBA|RLDUTSBA||
+
+ 头部每行一个 "键 值"，之后每帧一行输入。输入行的第一个字段是命令
+ （复位等，这里只支持 0），后面两个字段是 1P 和 2P 手柄（port1 为 0 时 2P 为空），
+ 按 RLDUTSBA 的顺序，空格或者 . 表示没按。
+
+ FCEUX 的 savestate 是它自己的格式，所以从即时存档开始的录像
+ 把本模拟器的存档放在 taonesState 里，FCEUX 会把它当成从开机开始。
+ 结束时内存的 CRC32 放在 taonesRAMHash 里，回放完成后用来检查是否同步。
+
+ 录像的第 n 帧是开始录像后 PPU 的第 n 帧，按键在这一帧里读手柄时生效。
+ 内存校验取在最后一帧结束的时刻（PPU 完成这一帧时），和单步的方式无关。
+
+ https://fceux.com/web/help/fm2.html
+*/
+
+var (
+	ErrBadMovie         = errors.New("nes: not a fm2 movie")
+	ErrMovieMismatch    = errors.New("nes: movie belongs to another rom")
+	ErrMovieRegion      = errors.New("nes: movie region mismatch")
+	ErrMoviePowerOn     = errors.New("nes: movie starts from power on, console already running")
+	ErrMovieUnsupported = errors.New("nes: movie uses unsupported commands")
+	ErrMovieBusy        = errors.New("nes: another movie is active")
+	ErrMovieNoHash      = errors.New("nes: movie has no ram hash")
+	ErrMovieDesync      = errors.New("nes: movie desynced")
+)
+
+// fm2 中手柄按键的顺序
+const movieButtons = "RLDUTSBA"
+
+// Movie 是一段输入录像
+type Movie struct {
+	ROMFilename string
+	ROMChecksum [16]byte // PRG 和 CHR 的 MD5
+	GUID        string
+	PAL         bool
+	Rerecords   int
+	Comments    []string
+
+	State  []byte       // 开始时的即时存档，为空表示从开机开始
+	Frames [][2][8]bool // 每帧 1P 和 2P 的按键
+	Port2  bool         // 是否录了 2P
+
+	RAMHash    uint32 // 结束时 CPU 内存的 CRC32
+	HasRAMHash bool
+}
+
+// NewMovie 创建一个属于当前卡带的空录像
+func (o *Console) NewMovie() *Movie {
+	return &Movie{
+		ROMChecksum: o.movieChecksum(),
+		GUID:        newGUID(),
+		PAL:         o.region == RegionPAL,
+	}
+}
+
+func (o *Console) movieChecksum() [16]byte {
+	h := md5.New()
+	h.Write(o.cart.PRG)
+	if !o.cart.chrRAM {
+		h.Write(o.cart.CHR)
+	}
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// RAMHash 返回 CPU 内存（2KB）的 CRC32
+func (o *Console) RAMHash() uint32 {
+	return crc32.ChecksumIEEE(o.cpu.RAM[:])
+}
+
+func newGUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// ReadMovie 读取 fm2 格式的录像
+func ReadMovie(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	version := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20) // 存档可能很长
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "|") {
+			buttons, err := parseMovieInput(line, m.Port2)
+			if err != nil {
+				return nil, err
+			}
+			m.Frames = append(m.Frames, buttons)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if key == "" {
+			continue
+		}
+
+		var err error
+
+		switch key {
+		case "version":
+			version = value == "3"
+		case "romFilename":
+			m.ROMFilename = value
+		case "romChecksum":
+			var sum []byte
+			sum, err = decodeMovieBase64(value)
+			if err == nil && len(sum) != len(m.ROMChecksum) {
+				err = ErrBadMovie
+			}
+			copy(m.ROMChecksum[:], sum)
+		case "guid":
+			m.GUID = value
+		case "palFlag":
+			m.PAL = value == "1"
+		case "rerecordCount":
+			m.Rerecords, err = strconv.Atoi(value)
+		case "comment":
+			m.Comments = append(m.Comments, value)
+		case "port0":
+			if value != "1" {
+				err = ErrMovieUnsupported
+			}
+		case "port1":
+			switch value {
+			case "0":
+			case "1":
+				m.Port2 = true
+			default:
+				err = ErrMovieUnsupported
+			}
+		case "port2", "fourscore", "microphone", "FDS":
+			if value != "0" {
+				err = ErrMovieUnsupported
+			}
+		case "taonesState":
+			m.State, err = decodeMovieBase64(value)
+		case "taonesRAMHash":
+			var v uint64
+			v, err = strconv.ParseUint(value, 16, 32)
+			m.RAMHash, m.HasRAMHash = uint32(v), true
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("fm2 %s: %w", key, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !version {
+		return nil, ErrBadMovie
+	}
+
+	return m, nil
+}
+
+// |命令|1P|2P|扩展|
+func parseMovieInput(line string, port2 bool) ([2][8]bool, error) {
+	var buttons [2][8]bool
+
+	fields := strings.Split(line, "|")
+	if len(fields) < 4 {
+		return buttons, ErrBadMovie
+	}
+
+	if cmd, err := strconv.Atoi(fields[1]); err != nil {
+		return buttons, ErrBadMovie
+	} else if cmd != 0 {
+		return buttons, ErrMovieUnsupported
+	}
+
+	ports := 1
+	if port2 {
+		ports = 2
+	}
+
+	for port := 0; port < ports; port++ {
+		pad := fields[2+port]
+		if len(pad) != len(movieButtons) {
+			return buttons, ErrBadMovie
+		}
+		for i := 0; i < len(movieButtons); i++ {
+			if pad[i] != ' ' && pad[i] != '.' {
+				buttons[port][len(movieButtons)-1-i] = true
+			}
+		}
+	}
+
+	return buttons, nil
+}
+
+// 按 RLDUTSBA 的顺序写出按键
+func formatMovieButtons(buttons [8]bool) string {
+	var pad [8]byte
+	for i := range pad {
+		pad[i] = '.'
+		if buttons[len(pad)-1-i] {
+			pad[i] = movieButtons[i]
+		}
+	}
+	return string(pad[:])
+}
+
+func decodeMovieBase64(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "base64:") {
+		return nil, ErrBadMovie
+	}
+	return base64.StdEncoding.DecodeString(s[len("base64:"):])
+}
+
+// Write 以 fm2 格式写出录像
+func (m *Movie) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	pal, port1 := 0, 0
+	if m.PAL {
+		pal = 1
+	}
+	if m.Port2 {
+		port1 = 1
+	}
+
+	fmt.Fprintf(bw, "version 3\n")
+	fmt.Fprintf(bw, "emuVersion 22020\n")
+	fmt.Fprintf(bw, "rerecordCount %d\n", m.Rerecords)
+	fmt.Fprintf(bw, "palFlag %d\n", pal)
+	fmt.Fprintf(bw, "romFilename %s\n", m.ROMFilename)
+	fmt.Fprintf(bw, "romChecksum base64:%s\n", base64.StdEncoding.EncodeToString(m.ROMChecksum[:]))
+	fmt.Fprintf(bw, "guid %s\n", m.GUID)
+	fmt.Fprintf(bw, "fourscore 0\nmicrophone 0\nport0 1\nport1 %d\nport2 0\nFDS 0\nNewPPU 0\n", port1)
+	for _, c := range m.Comments {
+		fmt.Fprintf(bw, "comment %s\n", c)
+	}
+	if m.State != nil {
+		fmt.Fprintf(bw, "taonesState base64:%s\n", base64.StdEncoding.EncodeToString(m.State))
+	}
+	if m.HasRAMHash {
+		fmt.Fprintf(bw, "taonesRAMHash %08X\n", m.RAMHash)
+	}
+
+	for _, buttons := range m.Frames {
+		pad2 := ""
+		if m.Port2 {
+			pad2 = formatMovieButtons(buttons[1])
+		}
+		fmt.Fprintf(bw, "|0|%s|%s||\n", formatMovieButtons(buttons[0]), pad2)
+	}
+
+	return bw.Flush()
+}
+
+// LoadMovieFile 读取录像文件
+func LoadMovieFile(path string) (*Movie, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return ReadMovie(fp)
+}
+
+// SaveFile 写入录像文件
+func (m *Movie) SaveFile(path string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// 录像和回放在帧结束时的处理，见 Console.endFrame
+type movieHook interface {
+	endFrame()
+}
+
+// 从开机开始的录像不使用电池存档
+func (o *Console) powerOnMovie() error {
+	if o.ppu.FrameCount != 0 {
+		return ErrMoviePowerOn
+	}
+	if o.cart.Battery {
+		for i := range o.cart.SRAM {
+			o.cart.SRAM[i] = 0
+		}
+	}
+	return nil
+}
+
+// MovieRecorder 录下手柄的按键
+type MovieRecorder struct {
+	console  *Console
+	movie    *Movie
+	start    uint64 // 开始时的帧数
+	stopping bool
+}
+
+// NewMovieRecorder 开始录像
+// fromState 为 false 时从开机开始，主机必须刚刚加载卡带还没有运行；
+// 为 true 时从当前状态开始，录像中保存一份即时存档
+func NewMovieRecorder(console *Console, fromState bool) (*MovieRecorder, error) {
+	if console.movie != nil {
+		return nil, ErrMovieBusy
+	}
+
+	movie := console.NewMovie()
+
+	if fromState {
+		var buf bytes.Buffer
+		if err := console.SaveState(&buf); err != nil {
+			return nil, err
+		}
+		movie.State = buf.Bytes()
+	} else if err := console.powerOnMovie(); err != nil {
+		return nil, err
+	}
+
+	o := &MovieRecorder{
+		console: console,
+		movie:   movie,
+		start:   console.ppu.FrameCount,
+	}
+	console.movie = o
+
+	return o, nil
+}
+
+// Controller 包装 port 口（0 是 1P，1 是 2P）的手柄，
+// 记录每一帧选通时锁存的按键（包括连发），
+// 读档后帧数回退，之后的帧会被重新录制
+func (o *MovieRecorder) Controller(port int, ctrl LatchedController) ControllerProvider {
+	if port == 1 {
+		o.movie.Port2 = true
+	}
+	return &movieController{LatchedController: ctrl, recorder: o, port: port}
+}
+
+func (o *MovieRecorder) record(port int, frameCounter uint64, buttons [8]bool) {
+	if o.console.movie != o || frameCounter < o.start {
+		return
+	}
+	i := int(frameCounter - o.start)
+	for len(o.movie.Frames) <= i {
+		o.movie.Frames = append(o.movie.Frames, [2][8]bool{})
+	}
+	o.movie.Frames[i][port] = buttons
+}
+
+type movieController struct {
+	LatchedController
+	recorder *MovieRecorder
+	port     int
+}
+
+func (o *movieController) Strobe(on bool, frameCounter uint64) {
+	o.LatchedController.Strobe(on, frameCounter)
+	if on {
+		o.recorder.record(o.port, frameCounter, o.Latched())
+	}
+}
+
+// 停止时在帧结束的时刻记下长度和内存校验
+func (o *MovieRecorder) endFrame() {
+	if !o.stopping {
+		return
+	}
+
+	n := int(o.console.ppu.FrameCount - o.start)
+	for len(o.movie.Frames) < n {
+		o.movie.Frames = append(o.movie.Frames, [2][8]bool{})
+	}
+	o.movie.Frames = o.movie.Frames[:n]
+
+	o.movie.RAMHash = o.console.RAMHash()
+	o.movie.HasRAMHash = true
+
+	o.console.movie = nil
+}
+
+// Stop 运行到当前帧结束，返回录像
+func (o *MovieRecorder) Stop() *Movie {
+	o.stopping = true
+	for o.console.movie == o {
+		o.console.Step()
+	}
+	return o.movie
+}
+
+// MoviePlayer 回放录像
+type MoviePlayer struct {
+	console *Console
+	movie   *Movie
+	start   uint64
+	done    bool
+	hash    uint32 // 最后一帧结束时的内存校验
+}
+
+// NewMoviePlayer 准备回放：从存档开始的录像会加载存档，
+// 从开机开始的录像要求主机刚刚加载卡带还没有运行
+// 两个手柄会换成按录像输入的手柄，见 Controller
+func NewMoviePlayer(console *Console, movie *Movie) (*MoviePlayer, error) {
+	if console.movie != nil {
+		return nil, ErrMovieBusy
+	}
+	if movie.ROMChecksum != console.movieChecksum() {
+		return nil, ErrMovieMismatch
+	}
+	if movie.PAL != (console.region == RegionPAL) {
+		return nil, ErrMovieRegion
+	}
+
+	o := &MoviePlayer{
+		console: console,
+		movie:   movie,
+	}
+
+	// 先插上手柄，存档里的手柄状态才有地方恢复
+	ctrl1, ctrl2 := console.ctrl1, console.ctrl2
+	console.SetController1(o.Controller(0))
+	console.SetController2(o.Controller(1))
+
+	var err error
+	if movie.State != nil {
+		err = console.LoadState(bytes.NewReader(movie.State))
+	} else {
+		err = console.powerOnMovie()
+	}
+	if err != nil {
+		console.ctrl1, console.ctrl2 = ctrl1, ctrl2
+		return nil, err
+	}
+
+	o.start = console.ppu.FrameCount
+
+	if len(movie.Frames) == 0 {
+		o.finish()
+	} else {
+		console.movie = o
+	}
+
+	return o, nil
+}
+
+// Controller 返回 port 口按录像输入的手柄，录像结束后不再按任何键
+func (o *MoviePlayer) Controller(port int) ControllerProvider {
+	return NewKeyboardController(func(frameCounter uint64) [8]bool {
+		if frameCounter >= o.start && frameCounter-o.start < uint64(len(o.movie.Frames)) {
+			return o.movie.Frames[frameCounter-o.start][port]
+		}
+		return [8]bool{}
+	})
+}
+
+func (o *MoviePlayer) endFrame() {
+	if o.console.ppu.FrameCount-o.start >= uint64(len(o.movie.Frames)) {
+		o.finish()
+	}
+}
+
+func (o *MoviePlayer) finish() {
+	o.hash = o.console.RAMHash()
+	o.done = true
+	o.console.movie = nil
+}
+
+// Done 是否已经回放完所有帧
+func (o *MoviePlayer) Done() bool {
+	return o.done
+}
+
+// Frame 返回当前回放到第几帧
+func (o *MoviePlayer) Frame() int {
+	return min(int(o.console.ppu.FrameCount-o.start), len(o.movie.Frames))
+}
+
+// Verify 在 Done 之后检查内存校验，不一致时返回 ErrMovieDesync
+func (o *MoviePlayer) Verify() error {
+	if !o.movie.HasRAMHash {
+		return ErrMovieNoHash
+	}
+	if o.hash != o.movie.RAMHash {
+		return fmt.Errorf("%w: ram hash %08X, want %08X", ErrMovieDesync, o.hash, o.movie.RAMHash)
+	}
+	return nil
+}
+
+// Stop 中途停止回放
+func (o *MoviePlayer) Stop() {
+	if o.console.movie == o {
+		o.console.movie = nil
+	}
+}
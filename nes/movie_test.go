@@ -0,0 +1,147 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMovieRoundTrip(t *testing.T) {
+	frames := [][2][8]bool{
+		{},
+		{{ButtonA: true, ButtonRight: true}, {ButtonB: true}},
+		{{true, true, true, true, true, true, true, true}, {ButtonStart: true}},
+	}
+
+	tests := []struct {
+		name  string
+		movie Movie
+	}{
+		{"power on", Movie{
+			ROMFilename: "game",
+			GUID:        "01234567-89AB-CDEF-0123-456789ABCDEF",
+			Frames:      [][2][8]bool{{}, {{ButtonA: true}}, {{ButtonUp: true, ButtonSelect: true}}},
+		}},
+		{"two players", Movie{
+			ROMFilename: "game",
+			ROMChecksum: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			PAL:         true,
+			Rerecords:   3,
+			Comments:    []string{"author someone", "first run"},
+			Frames:      frames,
+			Port2:       true,
+		}},
+		{"from state", Movie{
+			ROMFilename: "game",
+			State:       []byte("TNST\x04\x00\x00\x00state"),
+			Frames:      frames[:1],
+			RAMHash:     0x0123ABCD,
+			HasRAMHash:  true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.movie.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			movie, err := ReadMovie(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*movie, tt.movie) {
+				t.Fatalf("got %+v\nwant %+v\nfm2:\n%s", *movie, tt.movie, buf.String())
+			}
+		})
+	}
+}
+
+func TestReadMovieErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fm2     string
+		wantErr error
+	}{
+		{"empty", "", ErrBadMovie},
+		{"version", "version 2\n", ErrBadMovie},
+		{"checksum", "version 3\nromChecksum base64:AAAA\n", ErrBadMovie},
+		{"fourscore", "version 3\nfourscore 1\n", ErrMovieUnsupported},
+		{"reset", "version 3\nport0 1\n|1|........|||\n", ErrMovieUnsupported},
+		{"short input", "version 3\n|0|....|||\n", ErrBadMovie},
+		{"missing port2", "version 3\nport1 1\n|0|........|||\n", ErrBadMovie},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadMovie(strings.NewReader(tt.fm2))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// NMI 开始时不选通先读一次 $4016 累加到 $02，读到的位取决于之前留下的移位位置
+func midReadConsole(t *testing.T) *Console {
+	t.Helper()
+	cart := testCartridge()
+	nmi := []byte{
+		0xAD, 0x16, 0x40, 0x29, 0x01, // LDA $4016  AND #1
+		0x18, 0x65, 0x02, 0x85, 0x02, // CLC  ADC $02  STA $02
+	}
+	nmi = append(nmi, cart.PRG[0x100:0x120]...)
+	copy(cart.PRG[0x100:], nmi)
+
+	console := NewConsole()
+	if err := console.LoadCartridge(cart); err != nil {
+		t.Fatal(err)
+	}
+	return console
+}
+
+// 在手柄读到一半时开始录像，回放时必须接着同样的移位位置
+func TestMovieFromStateMidRead(t *testing.T) {
+	console := midReadConsole(t)
+	console.SetController1(NewKeyboardController(func(frameCounter uint64) [8]bool {
+		return [8]bool{true, true, true, true, true, true, true, true}
+	}))
+	for i := 0; i < 7; i++ {
+		console.StepFrame()
+	}
+
+	// 游戏读手柄读到一半，第 5 位还没有移出来
+	memory := console.cpu.MemoryReadWriter
+	memory.Write(0x4016, 1)
+	memory.Write(0x4016, 0)
+	for i := 0; i < 5; i++ {
+		memory.Read(0x4016)
+	}
+
+	recorder, err := NewMovieRecorder(console, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 和前端一样，开始录像时换上新的手柄
+	console.SetController1(recorder.Controller(0, NewKeyboardController(func(frameCounter uint64) [8]bool {
+		return [8]bool{ButtonRight: frameCounter%2 == 0}
+	})))
+	for i := 0; i < 10; i++ {
+		console.StepFrame()
+	}
+	movie := recorder.Stop()
+
+	console = midReadConsole(t)
+	player, err := NewMoviePlayer(console, movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !player.Done() {
+		console.StepFrame()
+	}
+	if err := player.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
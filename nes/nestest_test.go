@@ -0,0 +1,73 @@
+package nes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nestest.nes 和 nestest.log 需要自己放到 testdata 目录下：
+// https://www.nesdev.org/wiki/Emulator_tests
+//
+// 从 $C000 开始是不需要 PPU 的自动模式，逐条对比跟踪输出，
+// 报告第一条不一致的指令。
+func TestNestest(t *testing.T) {
+	romPath := filepath.Join("testdata", "nestest.nes")
+	logPath := filepath.Join("testdata", "nestest.log")
+
+	fp, err := os.Open(logPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skip("testdata/nestest.log not found")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	cart, err := LoadROM(romPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Skip("testdata/nestest.nes not found")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	console := NewConsole()
+	if err := console.LoadCartridge(cart); err != nil {
+		t.Fatal(err)
+	}
+
+	trace := &bytes.Buffer{}
+	console.cpu.PC = 0xC000
+	console.SetTracer(trace)
+
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		want := strings.TrimRight(scanner.Text(), "\r\n ")
+
+		// 每条指令执行之前输出一行，中断和 DMA 周期不输出
+		trace.Reset()
+		for trace.Len() == 0 {
+			console.Step()
+			if err := console.Halted(); err != nil {
+				t.Fatalf("line %d: %v", n, err)
+			}
+		}
+		got := strings.TrimRight(trace.String(), "\n ")
+
+		if got != want {
+			t.Fatalf("line %d differs:\nwant: %s\ngot:  %s", n, want, got)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// $02 和 $03 是官方和非官方指令测试的错误码
+	if r := console.cpu.RAM; r[2] != 0 || r[3] != 0 {
+		t.Errorf("nestest reported errors: $02=%02X $03=%02X", r[2], r[3])
+	}
+}
@@ -1,4 +1,8 @@
-package main
+package nes
+
+import (
+	"io"
+)
 
 var paletteColors = [64]uint{
 	0x666666, 0x002A88, 0x1412A7, 0x3B00A4, 0x5C007E, 0x6E0040, 0x6C0600, 0x561D00,
@@ -10,3 +14,18 @@ var paletteColors = [64]uint{
 	0xFFFEFF, 0xC0DFFF, 0xD3D2FF, 0xE8C8FF, 0xFBC2FF, 0xFEC4EA, 0xFECCC5, 0xF7D8A5,
 	0xE4E594, 0xCFEF96, 0xBDF4AB, 0xB3F3CC, 0xB5EBF2, 0xB8B8B8, 0x000000, 0x000000,
 }
+
+// ReadPalette 读取 .pal 调色板文件：64 个 RGB 三元组
+// 带强调色的 512 色文件只取前 64 个
+func ReadPalette(r io.Reader) ([64]uint, error) {
+	var colors [64]uint
+	var buf [64 * 3]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return colors, err
+	}
+	for i := range colors {
+		r, g, b := uint(buf[i*3+0]), uint(buf[i*3+1]), uint(buf[i*3+2])
+		colors[i] = r<<16 | g<<8 | b
+	}
+	return colors, nil
+}
@@ -1,47 +1,10 @@
-package main
+package nes
 
 import (
-	"log"
-	"time"
+	"encoding/gob"
+	"image"
 )
 
-type FPSCalculator struct {
-	lastFPS     float64 // 上一次的FPS
-	lastTime    int64   // 上一次的时间
-	smoothing   float64 // 平滑过渡参数
-	countPeriod uint    // 统计周期
-	count       uint    // 已统计次数
-}
-
-func NewFPSCalculator() *FPSCalculator {
-	return &FPSCalculator{
-		smoothing:   0.8,
-		countPeriod: 60,
-	}
-}
-
-func (o *FPSCalculator) Last() uint {
-	return uint(o.lastFPS)
-}
-
-func (o *FPSCalculator) Calc(frames uint64) bool {
-	if o.count++; o.count != o.countPeriod {
-		return false
-	}
-
-	now := time.Now().UnixNano()
-	elapsed := now - o.lastTime
-	currFPS := float64(o.countPeriod) / float64(elapsed) * 1e9
-
-	fps := o.lastFPS*(1-o.smoothing) + currFPS*o.smoothing
-
-	o.lastFPS = fps
-	o.lastTime = now
-	o.count = 0
-
-	return true
-}
-
 // PPU 控制寄存器 $2000
 type PPUCTRL struct {
 	ctrlNameTable       byte // 命名表基地址 0: $2000, 1: $2400, 2: $2800, 3: $2C00
@@ -63,6 +26,18 @@ func (o *PPUCTRL) Set(v byte) {
 	o.ctrlEnableNMI = v >> 7 & 1
 }
 
+func (o *PPUCTRL) Get() byte {
+	var v byte
+	v |= o.ctrlNameTable << 0
+	v |= o.ctrlIncrement << 2
+	v |= o.ctrlSpriteTable << 3
+	v |= o.ctrlBackgroundTable << 4
+	v |= o.ctrlSpriteSize << 5
+	v |= o.ctrlMasterSlave << 6
+	v |= o.ctrlEnableNMI << 7
+	return v
+}
+
 // PPU 掩码寄存器 $2001
 type PPUMASK struct {
 	maskGrayscale          byte // 灰阶图案 0: 彩色，1: 灰阶
@@ -86,6 +61,19 @@ func (o *PPUMASK) Set(v byte) {
 	o.maskEmphasizeBlue = v >> 7 & 1
 }
 
+func (o *PPUMASK) Get() byte {
+	var v byte
+	v |= o.maskGrayscale << 0
+	v |= o.maskShowLeftBackground << 1
+	v |= o.maskShowLeftSprites << 2
+	v |= o.maskShowBackground << 3
+	v |= o.maskShowSprites << 4
+	v |= o.maskEmphasizeRed << 5
+	v |= o.maskEmphasizeGreen << 6
+	v |= o.maskEmphasizeBlue << 7
+	return v
+}
+
 // PPU 状态寄存器 $2002
 type PPUSTAT struct {
 	statSpriteOverflow byte // 精灵数量溢出
@@ -129,15 +117,20 @@ type OAMDMAR byte
 
 type Pixeler func(x int, y int, color uint)
 
+// FrameCallback 在 PPU 完成一帧时调用，参数是已经完成的帧数
+type FrameCallback func(frame uint64)
+
 type PPU struct {
 	MemoryReadWriter
 	console *Console
 
 	pixeler Pixeler
 	buffer  []byte
+	picture *image.RGBA // 不管有没有设置缓冲区，总是画一份
+	colors  [64]uint    // 调色板对应的 RGB 颜色
 
 	palette   [32]byte
-	nameTable [2048]byte
+	nameTable [4096]byte // 主机只有 2K，四屏模式下卡带额外提供 2K
 	oam       [256]byte
 
 	// 寄存器
@@ -184,7 +177,12 @@ type PPU struct {
 	// 一帧的扫描线数
 	// NTSC 是 262 条
 	// 所以总扫描周期是：341*262 = 89342
-	Scanline int // [0,261]
+	// PAL 和 Dendy 是 312 条
+	Scanline int // [0,preLine]
+
+	preLine    int  // 预渲染扫描线
+	vblankLine int  // VBlank 开始的扫描线
+	skipOdd    bool // 奇数帧是否跳过一个周期（只有 NTSC）
 
 	// 帧计数器
 	FrameCount uint64
@@ -208,17 +206,23 @@ type PPU struct {
 	spritePositions [8]byte
 	spritePriorites [8]byte
 	spriteIndexes   [8]byte
-
-	fps *FPSCalculator
 }
 
 func NewPPU(console *Console) *PPU {
 	ppu := PPU{MemoryReadWriter: NewPPUMemory(console), console: console}
-	ppu.fps = NewFPSCalculator()
+	ppu.colors = paletteColors
+	ppu.picture = image.NewRGBA(image.Rect(0, 0, 256, 240))
+	ppu.setTiming(&timings[RegionNTSC])
 	ppu.Power()
 	return &ppu
 }
 
+func (o *PPU) setTiming(t *timing) {
+	o.preLine = t.preLine
+	o.vblankLine = t.vblankLine
+	o.skipOdd = t.skipOdd
+}
+
 func (o *PPU) SetPixeler(pixeler Pixeler) {
 	o.pixeler = pixeler
 }
@@ -230,6 +234,40 @@ func (o *PPU) SetBuffer(buf []byte) {
 	}
 }
 
+func (o *PPU) Save(enc *gob.Encoder) error {
+	return encodeAll(enc,
+		o.palette, o.nameTable, o.oam,
+		o.PPUCTRL.Get(), o.PPUMASK.Get(), o.PPUSTAT.Get(true), o.OAMADDR,
+		o.oddFrame, o.nmiOccurred, o.nmiPrevious, o.nmiDelay,
+		o.v, o.t, o.x, o.w,
+		o.Cycle, o.Scanline, o.FrameCount,
+		o.nameTableByte, o.attributeTableByte, o.tileByteLo, o.tileByteHi, o.tileData,
+		o.bufferedData, o.register,
+		o.spriteCount, o.spritePatterns, o.spritePositions, o.spritePriorites, o.spriteIndexes,
+	)
+}
+
+func (o *PPU) Load(dec *gob.Decoder) error {
+	var ctrl, mask, stat byte
+	err := decodeAll(dec,
+		&o.palette, &o.nameTable, &o.oam,
+		&ctrl, &mask, &stat, &o.OAMADDR,
+		&o.oddFrame, &o.nmiOccurred, &o.nmiPrevious, &o.nmiDelay,
+		&o.v, &o.t, &o.x, &o.w,
+		&o.Cycle, &o.Scanline, &o.FrameCount,
+		&o.nameTableByte, &o.attributeTableByte, &o.tileByteLo, &o.tileByteHi, &o.tileData,
+		&o.bufferedData, &o.register,
+		&o.spriteCount, &o.spritePatterns, &o.spritePositions, &o.spritePriorites, &o.spriteIndexes,
+	)
+	if err != nil {
+		return err
+	}
+	o.PPUCTRL.Set(ctrl)
+	o.PPUMASK.Set(mask)
+	o.PPUSTAT.Set(stat)
+	return nil
+}
+
 func (ppu *PPU) readRegister(address uint16) byte {
 	switch address {
 	case 0x2002:
@@ -238,10 +276,10 @@ func (ppu *PPU) readRegister(address uint16) byte {
 		return ppu.readOAMData()
 	case 0x2007:
 		return ppu.readData()
-	default:
-		log.Fatalln("err reading ppu register", address)
 	}
-	return 0
+	// 只写的寄存器（$2000 $2001 $2003 $2005 $2006 $4014）读到的是总线上残留的值，
+	// 变址寻址和读-改-写指令的哑读也会读到这里
+	return ppu.register
 }
 
 func (ppu *PPU) writeRegister(address uint16, value byte) {
@@ -263,8 +301,6 @@ func (ppu *PPU) writeRegister(address uint16, value byte) {
 		ppu.writeData(value)
 	case 0x4014:
 		ppu.writeDMA(value)
-	default:
-		log.Fatalf("error write ppu register: %x=%x\n", address, value)
 	}
 }
 
@@ -273,8 +309,9 @@ func (o *PPU) Power() {
 	o.PPUMASK.Set(0x00)
 	o.PPUSTAT.Set(0xA0) // TODO ???
 
-	o.Cycle = 340
-	o.Scanline = 240
+	// 和 nestest.log 一致，从第 0 条扫描线的第 0 个周期开始
+	o.Cycle = 0
+	o.Scanline = 0
 	o.FrameCount = 0
 }
 
@@ -320,18 +357,22 @@ func (o *PPU) writeOAMData(v byte) {
 	o.OAMADDR++
 }
 
+// OAM DMA 占用 513 个周期（奇数周期开始时 514 个）：
+// 一个等待周期（加上对齐），然后每个字节一读一写
 func (o *PPU) writeDMA(v byte) {
 	cpu := o.console.cpu
 	addr := uint16(v) << 8
+	cpu.tick()
+	if cpu.Cycles&1 != 0 {
+		cpu.tick()
+	}
 	for i := 0; i < 256; i++ {
-		o.oam[o.OAMADDR] = cpu.Read(addr)
+		b := cpu.read(addr)
+		cpu.tick()
+		o.oam[o.OAMADDR] = b
 		o.OAMADDR++
 		addr++
 	}
-	cpu.suspendCycles += 513
-	if cpu.Cycles&1 != 0 {
-		cpu.suspendCycles++
-	}
 }
 
 func (o *PPU) setVBlank() {
@@ -646,7 +687,13 @@ func (o *PPU) renderPixel() {
 		color = 0
 	}
 
-	c := paletteColors[o.readPalette(uint16(color))&0x3F]
+	c := o.colors[o.readPalette(uint16(color))&0x3F]
+
+	p := o.picture.Pix[(y*256+x)*4:]
+	p[0] = byte(c >> 16)
+	p[1] = byte(c >> 8)
+	p[2] = byte(c >> 0)
+	p[3] = 0xFF
 
 	if o.buffer != nil { // 如果设置了缓冲区
 		a := (y*256 + x) * 4
@@ -681,25 +728,30 @@ func (o *PPU) tick() {
 	}
 
 	if o.maskShowBackground != 0 || o.maskShowSprites != 0 {
-		if o.oddFrame && o.Scanline == 261 && o.Cycle == 339 {
+		if o.skipOdd && o.oddFrame && o.Scanline == o.preLine && o.Cycle == 339 {
 			o.Cycle = 0
 			o.Scanline = 0
-			o.FrameCount++
-			o.oddFrame = !o.oddFrame
+			o.endFrame()
 			return
 		}
 	}
 
 	if o.Cycle++; o.Cycle > 340 {
 		o.Cycle = 0
-		if o.Scanline++; o.Scanline > 261 {
+		if o.Scanline++; o.Scanline > o.preLine {
 			o.Scanline = 0
-			o.FrameCount++
-			o.oddFrame = !o.oddFrame
+			o.endFrame()
 		}
 	}
 }
 
+// 预渲染扫描线结束，一帧完成
+func (o *PPU) endFrame() {
+	o.FrameCount++
+	o.oddFrame = !o.oddFrame
+	o.console.endFrame()
+}
+
 // 精灵评估与精灵渲染是两个独立的过程
 // 所以此处一次性搞定精灵评估操作
 // 而没有按照周期一步一步来
@@ -734,6 +786,22 @@ func (o *PPU) evaluateSprites() {
 		o.statSpriteOverflow = 1
 	}
 	o.spriteCount = count
+	o.fetchDummySprites(count)
+}
+
+// 不足8个精灵时，硬件仍然会用 $FF 号图块把剩下的抓取周期走完
+// mapper（比如 MMC3）依赖这些抓取在地址总线上产生的 A12 变化
+func (o *PPU) fetchDummySprites(count int) {
+	var addr uint16
+	if o.ctrlSpriteSize == 0 {
+		addr = 0x1000*uint16(o.ctrlSpriteTable) + 0xFF*16
+	} else {
+		addr = 0x1000 + 0xFE*16
+	}
+	for i := count; i < 8; i++ {
+		o.Read(addr)
+		o.Read(addr + 8)
+	}
 }
 
 // 获取第i个精灵第row条扫描线的渲染数据
@@ -802,7 +870,7 @@ func (o *PPU) Step() {
 	prefetchCycle := o.Cycle >= 321 && o.Cycle <= 336
 	visibleLine := o.Scanline < 240
 	fetchCycle := prefetchCycle || visibleCycle
-	preLine := o.Scanline == 261
+	preLine := o.Scanline == o.preLine
 
 	if renderEnabled {
 		if visibleLine && visibleCycle {
@@ -852,23 +920,20 @@ func (o *PPU) Step() {
 			o.evaluateSprites()
 		} else {
 			o.spriteCount = 0
+			if preLine {
+				o.fetchDummySprites(0)
+			}
 		}
 	}
 
 	if o.Cycle == 1 {
 		switch o.Scanline {
-		case 241:
+		case o.vblankLine:
 			o.setVBlank()
-		case 261:
+		case o.preLine:
 			o.clrVBlank()
 			o.statSpriteHit = 0
 			o.statSpriteOverflow = 0
 		}
 	}
-
-	if o.Scanline == 0 && o.Cycle == 0 {
-		if o.fps.Calc(o.FrameCount) {
-			log.Println("FPS:", o.fps.Last())
-		}
-	}
 }
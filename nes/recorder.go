@@ -0,0 +1,121 @@
+package nes
+
+import (
+	"errors"
+	"image"
+	"math"
+	"os"
+)
+
+var ErrRecording = errors.New("nes: already recording")
+
+// Recorder 把每一帧的画面和声音写到 AVI 文件
+// 由 Console 在帧结束时驱动，见 Console.StartRecording
+type Recorder struct {
+	file      *os.File
+	avi       *AVIWriter
+	resampler *Resampler // 没有音轨时为空
+	samples   []float32
+	pcm       []int16
+	err       error // 第一个写入错误，之后的帧都丢掉
+}
+
+// sampleRate 为 0 时不录声音
+func newRecorder(path string, fps, cpuFreq float64, sampleRate int) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	avi, err := NewAVIWriter(file, 256, 240, fps, sampleRate)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	o := &Recorder{
+		file: file,
+		avi:  avi,
+	}
+	if sampleRate > 0 {
+		o.resampler = NewResampler(cpuFreq, sampleRate)
+	}
+
+	return o, nil
+}
+
+func (o *Recorder) sample(v float32) {
+	if o.resampler != nil {
+		o.resampler.Sample(v)
+	}
+}
+
+// 写入一帧画面和这一帧期间产生的声音
+func (o *Recorder) frame(img *image.RGBA) {
+	if o.err != nil {
+		return
+	}
+
+	if o.err = o.avi.WriteFrame(img); o.err != nil {
+		return
+	}
+
+	if o.resampler == nil {
+		return
+	}
+
+	o.samples = o.resampler.Read(o.samples[:0])
+	o.pcm = o.pcm[:0]
+	for _, s := range o.samples {
+		o.pcm = append(o.pcm, int16(math.Max(-1, math.Min(1, float64(s)))*math.MaxInt16))
+	}
+
+	o.err = o.avi.WriteAudio(o.pcm)
+}
+
+func (o *Recorder) close() error {
+	err := o.avi.Close()
+	if o.err != nil {
+		err = o.err
+	}
+	if e := o.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// StartRecording 开始录像到 path（AVI 格式），帧率和当前制式一致
+// sampleRate 是音轨的采样率，为 0 时不录声音
+func (o *Console) StartRecording(path string, sampleRate int) error {
+	if o.recorder != nil {
+		return ErrRecording
+	}
+
+	recorder, err := newRecorder(path, o.timing.frameRate, o.timing.cpuFreq, sampleRate)
+	if err != nil {
+		return err
+	}
+
+	o.recorder = recorder
+	o.updateSampler()
+
+	return nil
+}
+
+// StopRecording 结束录像，返回录像过程中第一个写入错误
+func (o *Console) StopRecording() error {
+	if o.recorder == nil {
+		return nil
+	}
+
+	recorder := o.recorder
+	o.recorder = nil
+	o.updateSampler()
+
+	return recorder.close()
+}
+
+// Recording 是否正在录像
+func (o *Console) Recording() bool {
+	return o.recorder != nil
+}
@@ -0,0 +1,89 @@
+package nes
+
+// https://wiki.nesdev.com/w/index.php/Cycle_reference_chart
+
+type Region byte
+
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionDendy
+)
+
+func (r Region) String() string {
+	switch r {
+	case RegionPAL:
+		return "PAL"
+	case RegionDendy:
+		return "Dendy"
+	default:
+		return "NTSC"
+	}
+}
+
+// 各个制式的时序参数
+type timing struct {
+	cpuFreq    float64 // CPU 频率
+	ppuNum     int     // 每个 CPU 周期 ppuNum/ppuDen 个 PPU 周期
+	ppuDen     int
+	frameRate  float64 // 帧率
+	preLine    int     // 预渲染扫描线，也是最后一条扫描线
+	vblankLine int     // VBlank 开始的扫描线
+	skipOdd    bool    // 渲染开启时奇数帧是否少一个周期
+
+	noise *[16]uint16
+	dmc   *[16]uint16
+
+	// 帧计数器的步进点（CPU 周期）
+	// 4步：1、2、3、4、4 结束；5步：5、5 结束
+	frameSteps [7]uint32
+}
+
+var timings = [...]timing{
+	RegionNTSC: {
+		cpuFreq:    1789773,
+		ppuNum:     3,
+		ppuDen:     1,
+		frameRate:  60.0988,
+		preLine:    261,
+		vblankLine: 241,
+		skipOdd:    true,
+		noise:      &noiseTable,
+		dmc:        &dmcTable,
+		frameSteps: [7]uint32{7457, 14913, 22371, 29829, 29830, 37281, 37282},
+	},
+	RegionPAL: {
+		cpuFreq:    1662607,
+		ppuNum:     16,
+		ppuDen:     5,
+		frameRate:  50.0070,
+		preLine:    311,
+		vblankLine: 241,
+		noise:      &noiseTablePAL,
+		dmc:        &dmcTablePAL,
+		frameSteps: [7]uint32{8313, 16627, 24939, 33252, 33253, 41565, 41566},
+	},
+	RegionDendy: {
+		cpuFreq:    1773448,
+		ppuNum:     3,
+		ppuDen:     1,
+		frameRate:  50.0070,
+		preLine:    311,
+		vblankLine: 291,
+		noise:      &noiseTable,
+		dmc:        &dmcTable,
+		frameSteps: [7]uint32{7457, 14913, 22371, 29829, 29830, 37281, 37282},
+	},
+}
+
+// Region 根据文件头推断卡带的制式，多区域的按 NTSC 算
+func (o *Cartridge) Region() Region {
+	switch o.Timing {
+	case TimingPAL:
+		return RegionPAL
+	case TimingDendy:
+		return RegionDendy
+	default:
+		return RegionNTSC
+	}
+}
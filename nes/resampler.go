@@ -0,0 +1,131 @@
+package nes
+
+import (
+	"math"
+)
+
+/*
+ APU 每个 CPU 周期输出一个采样（约 1.79MHz），需要降到声卡的采样率。
+
+ APU 的输出是阶梯状的，只在电平变化的时候才有意义，
+ 所以这里把每次变化当成一个阶跃，用带限的冲激（加窗 sinc）
+ 叠加到输出缓冲区里，取样时再积分回来（即 BLEP / blip_buf 的做法）。
+*/
+
+const (
+	resamplerTaps   = 16 // 每个冲激覆盖的输出采样数
+	resamplerPhases = 64 // 小数位置的量化精度
+)
+
+var resamplerKernel [resamplerPhases][resamplerTaps]float32
+
+func init() {
+	const cutoff = 0.45 // 相对于输出采样率
+
+	half := float64(resamplerTaps) / 2
+
+	for p := 0; p < resamplerPhases; p++ {
+		frac := float64(p) / resamplerPhases
+		var sum float64
+		var taps [resamplerTaps]float64
+		for k := 0; k < resamplerTaps; k++ {
+			t := float64(k) - half - frac + 1
+			x := 2 * cutoff * t
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			// Blackman 窗
+			w := (t + half) / (2 * half)
+			window := 0.42 - 0.5*math.Cos(2*math.Pi*w) + 0.08*math.Cos(4*math.Pi*w)
+			taps[k] = sinc * window
+			sum += taps[k]
+		}
+		// 归一化，保证阶跃最终收敛到准确的电平
+		for k := 0; k < resamplerTaps; k++ {
+			resamplerKernel[p][k] = float32(taps[k] / sum)
+		}
+	}
+}
+
+// Resampler 把 APU 的输出重采样到指定的采样率
+type Resampler struct {
+	inRate  float64
+	outRate float64
+	ratio   float64 // 每个输入采样前进的输出采样数
+
+	pos   float64   // 下一个输入采样在 buf 中的位置
+	last  float32   // 上一个输入电平
+	buf   []float32 // 冲激累加缓冲区
+	accum float32   // 积分器
+
+	// 一阶高通，去掉直流分量
+	hpIn  float32
+	hpOut float32
+}
+
+// inRate 是 CPU 频率，见 Console.CPUFrequency
+func NewResampler(inRate float64, outRate int) *Resampler {
+	o := &Resampler{
+		inRate:  inRate,
+		outRate: float64(outRate),
+	}
+	o.ratio = o.outRate / o.inRate
+	return o
+}
+
+// SetAdjust 微调重采样比例，用于动态码率控制
+// adjust 是相对值，比如 0.005 表示多输出 0.5% 的采样
+func (o *Resampler) SetAdjust(adjust float64) {
+	o.ratio = o.outRate / o.inRate * (1 + adjust)
+}
+
+// Sample 输入一个 APU 采样，可以直接作为 Sampler 使用
+func (o *Resampler) Sample(v float32) {
+	if delta := v - o.last; delta != 0 {
+		o.last = v
+		o.addDelta(delta)
+	}
+	o.pos += o.ratio
+}
+
+func (o *Resampler) addDelta(delta float32) {
+	i := int(o.pos)
+	phase := int((o.pos - float64(i)) * resamplerPhases)
+
+	if need := i + resamplerTaps; need > len(o.buf) {
+		o.buf = append(o.buf, make([]float32, need-len(o.buf))...)
+	}
+
+	kernel := &resamplerKernel[phase]
+	buf := o.buf[i : i+resamplerTaps]
+	for k, h := range kernel {
+		buf[k] += delta * h
+	}
+}
+
+// Available 返回已经可以读取的采样数
+func (o *Resampler) Available() int {
+	return int(o.pos)
+}
+
+// Read 读出所有已经完成的采样，追加到 out 后返回
+func (o *Resampler) Read(out []float32) []float32 {
+	n := int(o.pos)
+	if n > len(o.buf) {
+		o.buf = append(o.buf, make([]float32, n-len(o.buf))...)
+	}
+
+	for i := 0; i < n; i++ {
+		o.accum += o.buf[i]
+		o.hpOut = 0.999*o.hpOut + o.accum - o.hpIn
+		o.hpIn = o.accum
+		out = append(out, o.hpOut)
+	}
+
+	rest := copy(o.buf, o.buf[n:])
+	o.buf = o.buf[:rest]
+	o.pos -= float64(n)
+
+	return out
+}
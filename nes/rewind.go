@@ -0,0 +1,197 @@
+package nes
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+/*
+ 倒带缓冲区
+
+ 每隔 interval 帧保存一次即时存档，在帧完成后的第一个指令边界上保存。只完整保存最新的一份，
+ 更早的存档保存为和后一份的异或差值，再用 flate 压缩。
+ 相邻两帧的状态差别很小，异或之后绝大部分是 0，压缩率很高。
+
+ 倒带时从最新的存档开始，依次异或出前一份：
+
+   older = newer ^ delta
+
+ 差值放在环形缓冲区中，满了之后丢掉最老的，内存占用有上限。
+*/
+
+type Rewinder struct {
+	console  *Console
+	interval uint64 // 每隔多少帧保存一次
+	last     uint64 // 上次保存时的帧号
+
+	current []byte   // 最新的一份完整存档
+	deltas  [][]byte // 环形缓冲区，压缩后的差值
+	head    int      // 下一个写入的位置
+	size    int
+
+	buf bytes.Buffer
+	zw  *flate.Writer
+	err error // 自动保存时的错误，由 Err 取走
+}
+
+// NewRewinder 创建倒带缓冲区并挂到主机上，每 interval 帧保存一次，最多保留 seconds 秒
+func NewRewinder(console *Console, interval int, seconds float64) *Rewinder {
+	if interval < 1 {
+		interval = 1
+	}
+	n := int(seconds * console.FrameRate() / float64(interval))
+	if n < 1 {
+		n = 1
+	}
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	o := &Rewinder{
+		console:  console,
+		interval: uint64(interval),
+		deltas:   make([][]byte, n),
+		zw:       zw,
+	}
+	console.rewinder = o
+	return o
+}
+
+// Interval 返回两次保存之间的帧数，倒带时每份存档要显示这么多帧的时间
+func (o *Rewinder) Interval() int {
+	return int(o.interval)
+}
+
+// Err 返回并清除上次自动保存失败的原因
+func (o *Rewinder) Err() error {
+	err := o.err
+	o.err = nil
+	return err
+}
+
+// Reset 清空历史，比如加载了即时存档之后
+func (o *Rewinder) Reset() {
+	o.current = nil
+	o.head = 0
+	o.size = 0
+	for i := range o.deltas {
+		o.deltas[i] = nil
+	}
+}
+
+// Len 返回可以倒退的次数
+func (o *Rewinder) Len() int {
+	return o.size
+}
+
+// 主机在每帧完成后的指令边界上调用，到了间隔就保存一次
+func (o *Rewinder) capture() {
+	if err := o.save(); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+func (o *Rewinder) save() error {
+	frame := o.console.FrameCount()
+	if o.current != nil && frame-o.last < o.interval {
+		return nil
+	}
+
+	o.buf.Reset()
+	if err := o.console.SaveState(&o.buf); err != nil {
+		return err
+	}
+
+	state := append([]byte(nil), o.buf.Bytes()...)
+
+	if o.current != nil {
+		delta, err := o.compress(o.current, state)
+		if err != nil {
+			return err
+		}
+		o.deltas[o.head] = delta
+		o.head = (o.head + 1) % len(o.deltas)
+		if o.size < len(o.deltas) {
+			o.size++
+		}
+	}
+
+	o.current = state
+	o.last = frame
+
+	return nil
+}
+
+// Rewind 退回到上一份存档，没有更早的存档时返回 false
+func (o *Rewinder) Rewind() (bool, error) {
+	if o.size == 0 {
+		return false, nil
+	}
+
+	o.head = (o.head - 1 + len(o.deltas)) % len(o.deltas)
+	delta := o.deltas[o.head]
+	o.deltas[o.head] = nil
+	o.size--
+
+	older, err := o.decompress(o.current, delta)
+	if err != nil {
+		return false, err
+	}
+
+	if err := o.console.LoadState(bytes.NewReader(older)); err != nil {
+		return false, err
+	}
+
+	o.current = older
+	o.last = o.console.FrameCount()
+
+	return true, nil
+}
+
+// 差值的格式：older 的长度（uint32）+ older ^ newer
+// 两者长度不同时，短的一方按 0 补齐
+func (o *Rewinder) compress(older, newer []byte) ([]byte, error) {
+	n := len(older)
+	if len(newer) > n {
+		n = len(newer)
+	}
+
+	raw := make([]byte, 4+n)
+	binary.LittleEndian.PutUint32(raw, uint32(len(older)))
+	copy(raw[4:], older)
+	for i, b := range newer {
+		raw[4+i] ^= b
+	}
+
+	out := &bytes.Buffer{}
+	o.zw.Reset(out)
+	if _, err := o.zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := o.zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func (o *Rewinder) decompress(newer, delta []byte) ([]byte, error) {
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(delta)))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 4 {
+		return nil, ErrBadState
+	}
+
+	n := int(binary.LittleEndian.Uint32(raw))
+	if n > len(raw)-4 {
+		return nil, ErrBadState
+	}
+
+	older := raw[4 : 4+n]
+	for i := 0; i < n && i < len(newer); i++ {
+		older[i] ^= newer[i]
+	}
+
+	return older, nil
+}
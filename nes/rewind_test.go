@@ -0,0 +1,117 @@
+package nes
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 每次倒退都要回到对应的那一份存档
+func TestRewindStepBack(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int
+		seconds  float64
+		frames   int
+		wantLen  int
+	}{
+		{"every frame", 1, 1, 30, 29},
+		{"every 3 frames", 3, 1, 30, 9},
+		{"full buffer", 1, 0.2, 30, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			console := testConsole(t)
+			rewinder := NewRewinder(console, tt.interval, tt.seconds)
+
+			// 每次保存时的完整状态
+			var states [][]byte
+			for i := 0; i < tt.frames; i++ {
+				empty, last := rewinder.current == nil, rewinder.last
+				console.StepFrame()
+				if err := rewinder.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if empty || rewinder.last != last {
+					states = append(states, saveState(t, console))
+				}
+			}
+
+			if rewinder.Len() != tt.wantLen {
+				t.Fatalf("len = %d, want %d", rewinder.Len(), tt.wantLen)
+			}
+
+			for i := len(states) - 2; i >= len(states)-1-tt.wantLen; i-- {
+				ok, err := rewinder.Rewind()
+				if err != nil || !ok {
+					t.Fatalf("rewind %d: %v %v", i, ok, err)
+				}
+				if !bytes.Equal(saveState(t, console), states[i]) {
+					t.Fatalf("rewind %d: state mismatch", i)
+				}
+			}
+
+			if ok, err := rewinder.Rewind(); ok || err != nil {
+				t.Fatalf("rewind past the oldest state: %v %v", ok, err)
+			}
+		})
+	}
+}
+
+// 按时间运行时存档也保存在帧边界上，和逐帧运行时每帧结束的状态一样
+func TestRewindFrameBoundary(t *testing.T) {
+	var frames [][]byte
+	b := testConsole(t)
+	for i := 0; i < 40; i++ {
+		b.StepFrame()
+		frames = append(frames, saveState(t, b))
+	}
+
+	a := testConsole(t)
+	rewinder := NewRewinder(a, 3, 1)
+	for i := 0; i < 40; i++ {
+		a.StepSeconds(0.0123)
+	}
+	if err := rewinder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for rewinder.Len() > 0 {
+		if ok, err := rewinder.Rewind(); !ok || err != nil {
+			t.Fatalf("rewind: %v %v", ok, err)
+		}
+		frame := a.FrameCount()
+		if frame%3 != 1 {
+			t.Fatalf("frame %d, want every 3 frames from frame 1", frame)
+		}
+		if !bytes.Equal(saveState(t, a), frames[frame-1]) {
+			t.Fatalf("frame %d: state not at the end of the frame", frame)
+		}
+	}
+}
+
+// 预览的一帧不改变主机状态，也不调用帧回调
+func TestPreviewFrame(t *testing.T) {
+	console := testConsole(t)
+	rewinder := NewRewinder(console, 1, 1)
+	calls := 0
+	console.SetFrameCallback(func(frameCounter uint64) {
+		calls++
+	})
+	console.StepFrame()
+	console.StepSeconds(0.004)
+
+	state := saveState(t, console)
+	if err := console.PreviewFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if rewinder.Len() != 0 {
+		t.Errorf("rewinder captured the preview frame")
+	}
+	if calls != 1 {
+		t.Errorf("frame callback called %d times, want 1", calls)
+	}
+	if !bytes.Equal(saveState(t, console), state) {
+		t.Error("state changed after preview")
+	}
+}
@@ -0,0 +1,88 @@
+package nes
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SRAMPath 返回 ROM 对应的存档文件路径：同目录下同名的 .sav 文件
+func SRAMPath(romPath string) string {
+	ext := filepath.Ext(romPath)
+	return strings.TrimSuffix(romPath, ext) + ".sav"
+}
+
+// LoadSRAM 从文件中加载 SRAM，文件不存在时不算错误
+func (o *Cartridge) LoadSRAM(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			o.sramSaved = append(o.sramSaved[:0], o.SRAM...)
+			return nil
+		}
+		return err
+	}
+
+	defer fp.Close()
+
+	// 存档可能比 SRAM 短（比如其它模拟器只保存了一部分），读多少算多少
+	if _, err := io.ReadFull(fp, o.SRAM); err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	o.sramSaved = append(o.sramSaved[:0], o.SRAM...)
+
+	return nil
+}
+
+// SaveSRAM 把 SRAM 写入文件
+// SRAM 自上次加载/保存以来没有变化时什么也不做
+func (o *Cartridge) SaveSRAM(path string) error {
+	if bytes.Equal(o.SRAM, o.sramSaved) {
+		return nil
+	}
+
+	if err := writeFileAtomic(path, o.SRAM); err != nil {
+		return err
+	}
+
+	o.sramSaved = append(o.sramSaved[:0], o.SRAM...)
+
+	return nil
+}
+
+// 先写临时文件再改名，写到一半崩溃也不会破坏原来的文件
+func writeFileAtomic(path string, data []byte) error {
+	fp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := fp.Name()
+
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := fp.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+/*
+ 即时存档格式：
+
+ +--------+---------+---------+-----------------------------------+
+ | "TNST" | version |   crc   | gob: cpu ppu apu cart mapper ctrl |
+ +--------+---------+---------+-----------------------------------+
+
+ version 和 crc 都是小端 uint32，crc 是 PRG-ROM 和 CHR 的校验，
+ 防止把别的游戏的存档加载进来。
+
+ 每个部件自己负责保存和恢复自己的状态，按固定的顺序编码。
+ 增删字段时需要增加 stateVersion。
+*/
+
+const (
+	stateMagic   = "TNST"
+	stateVersion = 4
+)
+
+var (
+	ErrBadState      = errors.New("nes: not a save state")
+	ErrStateVersion  = errors.New("nes: unsupported save state version")
+	ErrStateMismatch = errors.New("nes: save state belongs to another rom")
+)
+
+// 依次编码所有的值
+func encodeAll(enc *gob.Encoder, values ...interface{}) error {
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 依次解码所有的值，values 必须都是指针
+func decodeAll(dec *gob.Decoder, values ...interface{}) error {
+	for _, v := range values {
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 卡带内容的校验，用来识别存档属于哪个游戏
+func (o *Console) romChecksum() uint32 {
+	h := crc32.NewIEEE()
+	h.Write(o.cart.PRG)
+	if !o.cart.chrRAM {
+		h.Write(o.cart.CHR)
+	}
+	return h.Sum32()
+}
+
+// SaveState 保存整个主机的状态
+func (o *Console) SaveState(w io.Writer) error {
+	var header [12]byte
+	copy(header[0:4], stateMagic)
+	binary.LittleEndian.PutUint32(header[4:8], stateVersion)
+	binary.LittleEndian.PutUint32(header[8:12], o.romChecksum())
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(w)
+
+	if err := encodeAll(enc, o.ppuCredit); err != nil {
+		return err
+	}
+	if err := o.cpu.Save(enc); err != nil {
+		return err
+	}
+	if err := o.ppu.Save(enc); err != nil {
+		return err
+	}
+	if err := o.apu.Save(enc); err != nil {
+		return err
+	}
+	if err := o.cart.Save(enc); err != nil {
+		return err
+	}
+	if err := o.mapper.Save(enc); err != nil {
+		return err
+	}
+	return encodeAll(enc, controllerState(o.ctrl1), controllerState(o.ctrl2))
+}
+
+// LoadState 恢复主机状态
+// 失败时主机保持加载之前的状态，除非回滚也失败了（错误里会说明）
+func (o *Console) LoadState(r io.Reader) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return ErrBadState
+	}
+
+	if string(header[0:4]) != stateMagic {
+		return ErrBadState
+	}
+	if binary.LittleEndian.Uint32(header[4:8]) != stateVersion {
+		return ErrStateVersion
+	}
+	if binary.LittleEndian.Uint32(header[8:12]) != o.romChecksum() {
+		return ErrStateMismatch
+	}
+
+	// 先备份当前状态，解码一半出错时用来回滚
+	backup := &bytes.Buffer{}
+	if err := o.SaveState(backup); err != nil {
+		return err
+	}
+
+	if err := o.loadState(gob.NewDecoder(r)); err != nil {
+		backup.Next(len(header))
+		if err2 := o.loadState(gob.NewDecoder(backup)); err2 != nil {
+			return fmt.Errorf("%w: %v, rollback failed: %v", ErrBadState, err, err2)
+		}
+		return fmt.Errorf("%w: %v", ErrBadState, err)
+	}
+
+	return nil
+}
+
+func (o *Console) loadState(dec *gob.Decoder) error {
+	if err := decodeAll(dec, &o.ppuCredit); err != nil {
+		return err
+	}
+	if err := o.cpu.Load(dec); err != nil {
+		return err
+	}
+	if err := o.ppu.Load(dec); err != nil {
+		return err
+	}
+	if err := o.apu.Load(dec); err != nil {
+		return err
+	}
+	if err := o.cart.Load(dec); err != nil {
+		return err
+	}
+	if err := o.mapper.Load(dec); err != nil {
+		return err
+	}
+
+	var ctrl1, ctrl2 ControllerState
+	if err := decodeAll(dec, &ctrl1, &ctrl2); err != nil {
+		return err
+	}
+	setControllerState(o.ctrl1, ctrl1)
+	setControllerState(o.ctrl2, ctrl2)
+	return nil
+}
+
+// SaveStateFile 把状态保存到文件，写入是原子的
+func (o *Console) SaveStateFile(path string) error {
+	buf := &bytes.Buffer{}
+	if err := o.SaveState(buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// LoadStateFile 从文件恢复状态
+func (o *Console) LoadStateFile(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return o.LoadState(fp)
+}
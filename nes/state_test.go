@@ -0,0 +1,143 @@
+package nes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// 一个不断改写内存的 NROM 程序，NMI 里读手柄、写 PRG-RAM 和 APU
+func testCartridge() *Cartridge {
+	prg := make([]byte, 32768)
+	reset := []byte{
+		0xA9, 0x80, 0x8D, 0x00, 0x20, // LDA #$80  STA $2000
+		0xA9, 0x1E, 0x8D, 0x01, 0x20, // LDA #$1E  STA $2001
+		0xE6, 0x00, // INC $00
+		0xA6, 0x00, // LDX $00
+		0xFE, 0x00, 0x03, // INC $0300,X
+		0x4C, 0x0A, 0x80, // JMP $800A
+	}
+	nmi := []byte{
+		0xA9, 0x01, 0x8D, 0x16, 0x40, // LDA #1  STA $4016
+		0xA9, 0x00, 0x8D, 0x16, 0x40, // LDA #0  STA $4016
+		0xAD, 0x16, 0x40, // LDA $4016
+		0x65, 0x01, 0x85, 0x01, // ADC $01  STA $01
+		0x8D, 0x00, 0x60, // STA $6000
+		0x8D, 0x02, 0x40, // STA $4002
+		0x40, // RTI
+	}
+	copy(prg, reset)
+	copy(prg[0x100:], nmi)
+	prg[0x7FFA], prg[0x7FFB] = 0x00, 0x81
+	prg[0x7FFC], prg[0x7FFD] = 0x00, 0x80
+	return NewCartridge(prg, make([]byte, 8192), 0, 0)
+}
+
+func testConsole(t *testing.T) *Console {
+	t.Helper()
+	console := NewConsole()
+	if err := console.LoadCartridge(testCartridge()); err != nil {
+		t.Fatal(err)
+	}
+	console.SetController1(NewKeyboardController(func(frameCounter uint64) [8]bool {
+		return [8]bool{frameCounter%3 == 0}
+	}))
+	return console
+}
+
+func saveState(t *testing.T, console *Console) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := console.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// 从存档继续运行，结果必须和没有存档、一直运行下去的完全一样
+func TestSaveStateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames int
+		extra  float64 // 存档前多运行的秒数，不在帧边界上
+	}{
+		{"power on", 0, 0},
+		{"frame boundary", 10, 0},
+		{"mid frame", 10, 0.0031},
+		{"vblank", 3, 0.0155},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testConsole(t)
+			for i := 0; i < tt.frames; i++ {
+				a.StepFrame()
+			}
+			a.StepSeconds(tt.extra)
+			state := saveState(t, a)
+
+			b := testConsole(t)
+			if err := b.LoadState(bytes.NewReader(state)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saveState(t, b), state) {
+				t.Fatal("state changed after loading")
+			}
+
+			for i := 0; i < 20; i++ {
+				a.StepFrame()
+				b.StepFrame()
+			}
+			if a.RAMHash() != b.RAMHash() || a.FrameCount() != b.FrameCount() {
+				t.Fatalf("ram hash %08X frame %d, want %08X frame %d",
+					b.RAMHash(), b.FrameCount(), a.RAMHash(), a.FrameCount())
+			}
+			if !bytes.Equal(saveState(t, a), saveState(t, b)) {
+				t.Fatal("states differ after running")
+			}
+		})
+	}
+}
+
+// 加载失败时主机保持原来的状态
+func TestLoadStateErrors(t *testing.T) {
+	console := testConsole(t)
+	console.StepFrame()
+	state := saveState(t, console)
+
+	other := NewConsole()
+	cart := testCartridge()
+	cart.PRG[0] = 0xEA
+	if err := other.LoadCartridge(cart); err != nil {
+		t.Fatal(err)
+	}
+
+	version := append([]byte(nil), state...)
+	version[4]++
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrBadState},
+		{"bad magic", append([]byte("XXXX"), state[4:]...), ErrBadState},
+		{"version", version, ErrStateVersion},
+		{"other rom", saveState(t, other), ErrStateMismatch},
+		{"truncated", state[:len(state)/2], ErrBadState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			console.StepFrame()
+			before := saveState(t, console)
+			err := console.LoadState(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(saveState(t, console), before) {
+				t.Fatal("state changed after failed load")
+			}
+		})
+	}
+}
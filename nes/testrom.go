@@ -0,0 +1,112 @@
+package nes
+
+import (
+	"errors"
+	"time"
+)
+
+/*
+ blargg 的测试 ROM 通过 $6000 开始的 PRG-RAM 报告结果：
+
+ $6000       状态：$80 运行中，$81 需要按复位键，其它是结果码（0 表示通过）
+ $6001-$6003 签名 $DE $B0 $61，签名正确时其它字节才有意义
+ $6004-      以 0 结尾的文本信息
+
+ https://github.com/christopherpow/nes-test-roms
+*/
+
+const (
+	testStatusRunning = 0x80
+	testStatusReset   = 0x81
+)
+
+// 按复位键之前等待的时间，测试要求至少 100ms
+const testResetDelay = 150 * time.Millisecond
+
+var ErrTestTimeout = errors.New("nes: test rom timed out")
+
+// TestResult 是一个测试 ROM 的运行结果
+type TestResult struct {
+	Status  byte          // $6000 的结果码
+	Message string        // $6004 开始的文本
+	Elapsed time.Duration // 模拟器内经过的时间
+	Err     error         // 超时或者 CPU 停机
+}
+
+// Passed 测试正常结束并且结果码为 0
+func (r *TestResult) Passed() bool {
+	return r.Err == nil && r.Status == 0
+}
+
+// RunTestROM 运行测试 ROM，直到它报告结果或者模拟时间超过 timeout
+func RunTestROM(cart *Cartridge, timeout time.Duration) (*TestResult, error) {
+	console := NewConsole()
+	if err := console.LoadCartridge(cart); err != nil {
+		return nil, err
+	}
+
+	result := &TestResult{}
+	frameTime := time.Duration(float64(time.Second) / console.FrameRate())
+
+	var resetAt time.Duration = -1
+
+	for result.Elapsed < timeout {
+		console.StepFrame()
+		result.Elapsed += frameTime
+
+		if err := console.Halted(); err != nil {
+			result.Err = err
+			break
+		}
+
+		if resetAt >= 0 {
+			if result.Elapsed >= resetAt {
+				console.Reset()
+				resetAt = -1
+			}
+			continue
+		}
+
+		if !testSignature(cart.SRAM) {
+			continue
+		}
+
+		status := cart.SRAM[0]
+
+		if status == testStatusReset {
+			resetAt = result.Elapsed + testResetDelay
+			continue
+		}
+
+		if status != testStatusRunning {
+			result.Status = status
+			result.Message = testMessage(cart.SRAM)
+			return result, nil
+		}
+	}
+
+	if result.Err == nil {
+		result.Err = ErrTestTimeout
+	}
+	if testSignature(cart.SRAM) {
+		result.Status = cart.SRAM[0]
+		result.Message = testMessage(cart.SRAM)
+	}
+
+	return result, nil
+}
+
+func testSignature(sram []byte) bool {
+	return len(sram) > 4 && sram[1] == 0xDE && sram[2] == 0xB0 && sram[3] == 0x61
+}
+
+func testMessage(sram []byte) string {
+	msg := sram[4:]
+	for i, c := range msg {
+		if c == 0 {
+			msg = msg[:i]
+			break
+		}
+	}
+	return string(msg)
+}
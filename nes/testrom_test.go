@@ -0,0 +1,128 @@
+package nes
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/roms 下面放 blargg 风格的测试 ROM（可以有子目录），
+// 每个 ROM 是一个子测试，比如：
+//
+//	go test ./nes -run TestROMs/cpu_instrs
+func TestROMs(t *testing.T) {
+	dir := filepath.Join("testdata", "roms")
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		t.Skip("testdata/roms not found")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	var roms []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".nes") {
+			roms = append(roms, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range roms {
+		path := path
+		name, _ := filepath.Rel(dir, path)
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			t.Parallel()
+
+			cart, err := LoadROM(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := RunTestROM(cart, 60*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Passed() {
+				t.Errorf("status $%02X, %v\n%s", result.Status, result.Err, result.Message)
+			}
+		})
+	}
+}
+
+// 按 $6000 的协议报告 status 的测试 ROM，报告之前先运行 body
+func testROM(body []byte, status byte) *Cartridge {
+	program := []byte{
+		0xA9, 0x80, 0x8D, 0x00, 0x60, // LDA #$80  STA $6000
+		0xA9, 0xDE, 0x8D, 0x01, 0x60, // LDA #$DE  STA $6001
+		0xA9, 0xB0, 0x8D, 0x02, 0x60, // LDA #$B0  STA $6002
+		0xA9, 0x61, 0x8D, 0x03, 0x60, // LDA #$61  STA $6003
+		0xA9, 'o', 0x8D, 0x04, 0x60, // LDA #'o'  STA $6004
+		0xA9, 'k', 0x8D, 0x05, 0x60, // LDA #'k'  STA $6005
+		0xA9, 0x00, 0x8D, 0x06, 0x60, // LDA #0  STA $6006
+	}
+	program = append(program, body...)
+	program = append(program, 0xA9, status, 0x8D, 0x00, 0x60) // LDA #status  STA $6000
+	loop := 0x8000 + len(program)
+	program = append(program, 0x4C, byte(loop), byte(loop>>8)) // JMP *
+
+	prg := make([]byte, 32768)
+	copy(prg, program)
+	prg[0x7FFC], prg[0x7FFD] = 0x00, 0x80
+	return NewCartridge(prg, make([]byte, 8192), 0, 0)
+}
+
+var errHalted = errors.New("halted")
+
+func TestRunTestROM(t *testing.T) {
+	tests := []struct {
+		name    string
+		cart    *Cartridge
+		passed  bool
+		status  byte
+		wantErr error // nil 表示没有错误，errHalted 表示 CPU 停机
+	}{
+		{"pass", testROM(nil, 0), true, 0, nil},
+		{"fail", testROM(nil, 3), false, 3, nil},
+		// 读写只写的 PPU 寄存器不能让整个测试进程退出
+		{"write-only registers", testROM([]byte{
+			0xA2, 0x00, // LDX #0
+			0x9D, 0x00, 0x20, // STA $2000,X
+			0xAD, 0x05, 0x20, // LDA $2005
+			0xEE, 0x06, 0x20, // INC $2006
+			0xAD, 0x14, 0x40, // LDA $4014
+		}, 0), true, 0, nil},
+		{"halt", testROM([]byte{0x02}, 0), false, testStatusRunning, errHalted},
+		{"timeout", testROM(nil, testStatusRunning), false, testStatusRunning, ErrTestTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RunTestROM(tt.cart, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Passed() != tt.passed || result.Status != tt.status {
+				t.Fatalf("passed %v status $%02X, want %v $%02X (%v)",
+					result.Passed(), result.Status, tt.passed, tt.status, result.Err)
+			}
+			var halt *HaltError
+			switch {
+			case tt.wantErr == errHalted && !errors.As(result.Err, &halt),
+				tt.wantErr != errHalted && !errors.Is(result.Err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", result.Err, tt.wantErr)
+			}
+			if result.Message != "ok" {
+				t.Errorf("message = %q, want %q", result.Message, "ok")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package nes
+
+// TurboController 给手柄加上连发：按住某个按钮的连发键时，
+// 这个按钮按 on 帧、松 off 帧地交替按下
+// 节奏只取决于选通时的帧数，所以录像回放时完全一致
+type TurboController struct {
+	ctrl    LatchedController
+	turbo   func(frameCounter uint64) [8]bool // 按住的连发键
+	on, off uint64
+
+	buttons [8]bool // 锁存的按键，包括连发
+	index   byte
+	strobe  bool
+}
+
+// NewTurboController 包装 ctrl，turbo 返回这一帧按住了哪些按钮的连发键
+// on 和 off 是按下和松开的帧数，至少为 1
+func NewTurboController(ctrl LatchedController, turbo func(frameCounter uint64) [8]bool, on, off int) *TurboController {
+	return &TurboController{
+		ctrl:  ctrl,
+		turbo: turbo,
+		on:    uint64(max(on, 1)),
+		off:   uint64(max(off, 1)),
+	}
+}
+
+func (o *TurboController) Strobe(on bool, frameCounter uint64) {
+	o.ctrl.Strobe(on, frameCounter)
+	o.strobe = on
+	if !on {
+		return
+	}
+
+	o.buttons = o.ctrl.Latched()
+	if frameCounter%(o.on+o.off) < o.on {
+		for i, turbo := range o.turbo(frameCounter) {
+			o.buttons[i] = o.buttons[i] || turbo
+		}
+	}
+	o.index = 0
+}
+
+// 连发的按钮按下时第 0 位强制为 1，其它位来自被包装的手柄
+func (o *TurboController) Read(bus byte) byte {
+	v := o.ctrl.Read(bus)
+	if o.index < 8 && o.buttons[o.index] {
+		v |= 1
+	}
+	return v
+}
+
+func (o *TurboController) Shift() {
+	o.ctrl.Shift()
+	if !o.strobe && o.index < 8 {
+		o.index++
+	}
+}
+
+func (o *TurboController) Latched() [8]bool {
+	return o.buttons
+}
+
+func (o *TurboController) State() ControllerState {
+	return ControllerState{Buttons: o.buttons, Index: o.index, Strobe: o.strobe}
+}
+
+// 被包装的手柄恢复成同样的状态，锁存的按键里已经包括了连发
+func (o *TurboController) SetState(s ControllerState) {
+	o.ctrl.SetState(s)
+	o.buttons, o.index, o.strobe = s.Buttons, s.Index, s.Strobe
+}
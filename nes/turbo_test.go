@@ -0,0 +1,76 @@
+package nes
+
+import "testing"
+
+func TestTurboCadence(t *testing.T) {
+	tests := []struct {
+		name    string
+		on, off int
+		want    string // 前 12 帧 B 是否按下
+	}{
+		{"1/1", 1, 1, "101010101010"},
+		{"1/3", 1, 3, "100010001000"},
+		{"2/3", 2, 3, "110001100011"},
+		{"clamped", 0, 0, "101010101010"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyboard := NewKeyboardController(func(frameCounter uint64) [8]bool {
+				return [8]bool{ButtonA: true}
+			})
+			turbo := NewTurboController(keyboard, func(frameCounter uint64) [8]bool {
+				return [8]bool{ButtonB: true}
+			}, tt.on, tt.off)
+
+			got := ""
+			for frame := uint64(0); frame < uint64(len(tt.want)); frame++ {
+				turbo.Strobe(true, frame)
+				turbo.Strobe(false, frame)
+
+				var bits [8]byte
+				for i := range bits {
+					bits[i] = turbo.Read(controllerOpenBus) & 1
+					turbo.Shift()
+				}
+				if bits[ButtonA] != 1 {
+					t.Fatalf("frame %d: A not pressed", frame)
+				}
+				if pressed := turbo.Latched()[ButtonB]; pressed != (bits[ButtonB] == 1) {
+					t.Fatalf("frame %d: latched %v, read %d", frame, pressed, bits[ButtonB])
+				}
+
+				got += string('0' + bits[ButtonB])
+			}
+
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// 没有按住连发键时和被包装的手柄完全一样
+func TestTurboReleased(t *testing.T) {
+	buttons := [8]bool{ButtonB: true, ButtonStart: true, ButtonLeft: true}
+	turbo := NewTurboController(NewKeyboardController(func(frameCounter uint64) [8]bool {
+		return buttons
+	}), func(frameCounter uint64) [8]bool {
+		return [8]bool{}
+	}, 1, 1)
+
+	for frame := uint64(0); frame < 4; frame++ {
+		turbo.Strobe(true, frame)
+		turbo.Strobe(false, frame)
+		for i, pressed := range buttons {
+			want := controllerOpenBus
+			if pressed {
+				want |= 1
+			}
+			if v := turbo.Read(controllerOpenBus); v != byte(want) {
+				t.Fatalf("frame %d bit %d: got $%02X, want $%02X", frame, i, v, want)
+			}
+			turbo.Shift()
+		}
+	}
+}
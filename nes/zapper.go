@@ -0,0 +1,97 @@
+package nes
+
+/*
+ 光枪（Zapper），一般插在 2P 口，读 $4017：
+
+ D3 光线感应：0 表示看到了亮光，1 表示没有
+ D4 扳机：1 表示扣下
+
+ 枪口的光敏元件只在电子束扫过瞄准的位置之后的一小段时间里有反应，
+ 游戏在 NMI 之后不停地读 $4017，配合画面上的白框判断有没有打中。
+ 这里按 PPU 当前扫描到的位置判断：电子束已经经过瞄准点、还在之后的
+ zapperLightLines 条扫描线之内，并且瞄准点附近已经画出来的像素足够亮。
+
+ https://wiki.nesdev.com/w/index.php/Zapper
+*/
+
+const (
+	zapperLightLines = 20   // 光敏元件保持响应的扫描线数
+	zapperRadius     = 3    // 瞄准点周围检查的像素范围
+	zapperBrightness = 0xC0 // 足够亮的亮度（0~255）
+)
+
+// Zapper 光枪，aim 返回瞄准的画面坐标（不在画面内时为负数）和扳机是否扣下
+type Zapper struct {
+	console *Console
+	aim     func() (x, y int, trigger bool)
+}
+
+func NewZapper(console *Console, aim func() (x, y int, trigger bool)) *Zapper {
+	return &Zapper{
+		console: console,
+		aim:     aim,
+	}
+}
+
+// 光枪不使用选通和移位
+func (o *Zapper) Strobe(on bool, frameCounter uint64) {
+
+}
+
+func (o *Zapper) Shift() {
+
+}
+
+func (o *Zapper) Read(bus byte) byte {
+	x, y, trigger := o.aim()
+
+	v := bus & 0xE0
+	if !o.light(x, y) {
+		v |= 0x08
+	}
+	if trigger {
+		v |= 0x10
+	}
+	return v
+}
+
+// 瞄准点附近是否有刚被电子束画过的亮光
+func (o *Zapper) light(x, y int) bool {
+	if x < 0 || y < 0 || x >= 256 || y >= 240 {
+		return false
+	}
+
+	ppu := o.console.ppu
+
+	// 电子束不在可见的扫描线上（VBlank 等）时没有光
+	if ppu.Scanline >= 240 {
+		return false
+	}
+
+	// 正在画的像素
+	line, dot := ppu.Scanline, ppu.Cycle-1
+
+	// 电子束还没到瞄准点，或者已经过去太久
+	if line < y || line == y && dot <= x || line-y > zapperLightLines {
+		return false
+	}
+
+	for py := max(y-zapperRadius, 0); py <= min(y+zapperRadius, 239); py++ {
+		for px := max(x-zapperRadius, 0); px <= min(x+zapperRadius, 255); px++ {
+			// 只看这一帧已经画出来的像素
+			if py > line || py == line && px >= dot {
+				continue
+			}
+			p := ppu.picture.Pix[(py*256+px)*4:]
+			if luminance(p[0], p[1], p[2]) >= zapperBrightness {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func luminance(r, g, b byte) int {
+	return (299*int(r) + 587*int(g) + 114*int(b)) / 1000
+}
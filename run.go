@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/movsb/taones/nes"
+)
+
+// taones run -frames N -rom X [-dump out.png] [-dump-every N -dump-dir dir] [-record out.avi]
+// taones run -movie X.fm2 -rom X
+// 不打开窗口，运行固定的帧数，可以把最后一帧保存成图片，用于 CI；
+// 回放输入录像时运行录像的帧数，最后检查内存校验
+func runFrames(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	frames := flags.Int("frames", 60, "number of frames to run")
+	romPath := flags.String("rom", "", "rom to run")
+	dump := flags.String("dump", "", "write the last frame to a png file")
+	every := flags.Int("dump-every", 0, "write every Nth frame to -dump-dir, 0 to disable")
+	dumpDir := flags.String("dump-dir", ".", "directory for -dump-every frames")
+	record := flags.String("record", "", "record video and audio to an avi file")
+	recordAudio := flags.Int("record-audio", 44100, "audio sample rate for -record, 0 for video only")
+	moviePath := flags.String("movie", "", "play back an fm2 input movie and check its ram hash")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s run [flags]\n\nflags:\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *romPath == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cart, err := nes.LoadROM(*romPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	console := nes.NewConsole()
+	if err := console.LoadCartridge(cart); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var player *nes.MoviePlayer
+	if *moviePath != "" {
+		movie, err := nes.LoadMovieFile(*moviePath)
+		if err == nil {
+			player, err = nes.NewMoviePlayer(console, movie)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*frames = len(movie.Frames)
+	}
+
+	if *record != "" {
+		if err := console.StartRecording(*record, *recordAudio); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	code := stepFrames(console, *frames, *every, *dumpDir)
+
+	if err := console.StopRecording(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if code != 0 {
+		return code
+	}
+
+	if player != nil {
+		if err := player.Verify(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("movie ok: %d frames\n", *frames)
+	}
+
+	if *dump != "" {
+		if err := writePNG(*dump, console.Frame()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// 运行 frames 帧，每 every 帧保存一张图片
+func stepFrames(console *nes.Console, frames, every int, dumpDir string) int {
+	for i := 0; i < frames; i++ {
+		console.StepFrame()
+		if err := console.Halted(); err != nil {
+			fmt.Fprintf(os.Stderr, "frame %d: %v\n", console.FrameCount(), err)
+			return 1
+		}
+		if n := console.FrameCount(); every > 0 && n%uint64(every) == 0 {
+			path := filepath.Join(dumpDir, fmt.Sprintf("frame-%06d.png", n))
+			if err := writePNG(path, console.Frame()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+func writePNG(path string, img image.Image) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(fp, img); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/movsb/taones/nes"
+)
+
+// taones test [flags] <dir>
+// 运行目录下所有的测试 ROM，打印结果表格，有失败时退出码为 1
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	timeout := flags.Duration("timeout", time.Minute, "emulated time limit for each rom")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s test [flags] <dir>\n\nflags:\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	dir := flags.Arg(0)
+
+	roms, err := findROMs(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ROM\tRESULT\tMESSAGE")
+
+	failed := 0
+
+	for _, path := range roms {
+		name, _ := filepath.Rel(dir, path)
+
+		result, err := runTestROM(path, *timeout)
+
+		var status, message string
+		switch {
+		case err != nil:
+			status, message = "error", err.Error()
+		case result.Passed():
+			status, message = "pass", result.Message
+		case result.Err != nil:
+			status, message = "fail", result.Err.Error()
+			if result.Message != "" {
+				message += ": " + result.Message
+			}
+		default:
+			status, message = fmt.Sprintf("fail #%d", result.Status), result.Message
+		}
+
+		if status != "pass" {
+			failed++
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", filepath.ToSlash(name), status, oneLine(message))
+		w.Flush()
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", len(roms)-failed, failed)
+	w.Flush()
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runTestROM(path string, timeout time.Duration) (*nes.TestResult, error) {
+	cart, err := nes.LoadROM(path)
+	if err != nil {
+		return nil, err
+	}
+	return nes.RunTestROM(cart, timeout)
+}
+
+// 递归查找目录下所有的 .nes 文件
+func findROMs(dir string) ([]string, error) {
+	var roms []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".nes") {
+			roms = append(roms, path)
+		}
+		return nil
+	})
+	return roms, err
+}
+
+// 测试信息通常有多行，表格里合成一行
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}